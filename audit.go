@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+// auditValue is the state of an object before or after a change, it never
+// contains secrets like password hashes.
+type auditValue map[string]interface{}
+
+// newAuditValue converts v (a record for machine-readable output) to an
+// auditValue, nil is returned unchanged.
+func newAuditValue(v interface{}) (auditValue, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case auditValue:
+		return v, nil
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("converting %T for the audit log failed: %v", v, err)
+	}
+
+	var res auditValue
+	err = json.Unmarshal(buf, &res)
+	if err != nil {
+		return nil, fmt.Errorf("converting %T for the audit log failed: %v", v, err)
+	}
+
+	return res, nil
+}
+
+// String returns the value as JSON.
+func (v auditValue) String() string {
+	if v == nil {
+		return ""
+	}
+
+	buf, err := json.Marshal(map[string]interface{}(v))
+	if err != nil {
+		return fmt.Sprintf("%v", map[string]interface{}(v))
+	}
+
+	return string(buf)
+}
+
+// Value stores the value as JSON text, nil is stored as NULL.
+func (v auditValue) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return v.String(), nil
+}
+
+// Scan reads the value from JSON text.
+func (v *auditValue) Scan(src interface{}) error {
+	var buf []byte
+	switch s := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		buf = []byte(s)
+	case []byte:
+		buf = s
+	default:
+		return fmt.Errorf("unable to scan %T into auditValue", src)
+	}
+
+	return json.Unmarshal(buf, v)
+}
+
+// fields returns the names of all fields in v, sorted.
+func (v auditValue) fields() []string {
+	var names []string
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Changes returns the differences between the values before and after the
+// change for display. For created and removed objects, all fields are listed.
+func (e auditEntry) Changes() string {
+	var list []string
+	switch {
+	case e.Before == nil:
+		for _, name := range e.After.fields() {
+			list = append(list, fmt.Sprintf("%v %v", name, e.After[name]))
+		}
+	case e.After == nil:
+		for _, name := range e.Before.fields() {
+			list = append(list, fmt.Sprintf("%v %v", name, e.Before[name]))
+		}
+	default:
+		var fields fieldChanges
+		for _, name := range e.After.fields() {
+			before, ok := e.Before[name]
+			if !ok {
+				before = "-"
+			}
+			fields.add(name, fmt.Sprint(before), fmt.Sprint(e.After[name]))
+		}
+		list = fields
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// auditEntry describes a change made through vmail.
+type auditEntry struct {
+	Time    string     `db:"created_at" json:"time" yaml:"time"`
+	User    string     `db:"os_user" json:"user" yaml:"user"`
+	Command string     `db:"command" json:"command" yaml:"command"`
+	Action  string     `db:"action" json:"action" yaml:"action"`
+	Object  string     `db:"object" json:"object" yaml:"object"`
+	Before  auditValue `db:"old_value" json:"before,omitempty" yaml:"before,omitempty"`
+	After   auditValue `db:"new_value" json:"after,omitempty" yaml:"after,omitempty"`
+}
+
+const auditColumns = "created_at, os_user, command, action, object, old_value, new_value"
+
+// auditUser returns the name of the user running vmail. When run with sudo,
+// the original user is included.
+func auditUser() string {
+	name := os.Getenv("USER")
+	if cur, err := user.Current(); err == nil {
+		name = cur.Username
+	}
+
+	if sudo := os.Getenv("SUDO_USER"); sudo != "" && sudo != name {
+		name += " (sudo from " + sudo + ")"
+	}
+
+	return name
+}
+
+// secretFlags are the flags whose values are not recorded in the audit log,
+// the function returns the value to record instead.
+var secretFlags = map[string]func(string) string{
+	"--password":      redactValue,
+	"--password-hash": redactValue,
+	"--database":      dsnWithoutPassword,
+}
+
+// redactValue returns the placeholder recorded instead of a secret value.
+func redactValue(string) string {
+	return "***"
+}
+
+// auditCommand returns the command line with the values of secretFlags
+// replaced.
+func auditCommand(args []string) string {
+	var res []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		for flag, redact := range secretFlags {
+			if arg == flag && i+1 < len(args) {
+				res = append(res, arg)
+				i++
+				arg = redact(args[i])
+				break
+			}
+
+			if strings.HasPrefix(arg, flag+"=") {
+				arg = flag + "=" + redact(strings.TrimPrefix(arg, flag+"="))
+				break
+			}
+		}
+
+		res = append(res, arg)
+	}
+
+	return strings.Join(res, " ")
+}
+
+// auditLog stores the entries either in the table audit_log or, if filename
+// is set, in a file with one JSON document per line.
+type auditLog struct {
+	filename string
+}
+
+// record adds an entry to the audit log. Within a transaction, the entry is
+// kept until the transaction is committed.
+func (db *DB) record(action, object string, before, after interface{}) error {
+	entry := auditEntry{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		User:    auditUser(),
+		Command: auditCommand(os.Args),
+		Action:  action,
+		Object:  object,
+	}
+
+	var err error
+	entry.Before, err = newAuditValue(before)
+	if err != nil {
+		return err
+	}
+
+	entry.After, err = newAuditValue(after)
+	if err != nil {
+		return err
+	}
+
+	if db.pending != nil {
+		*db.pending = append(*db.pending, entry)
+		return nil
+	}
+
+	err = db.writeAudit([]auditEntry{entry})
+	if err != nil {
+		return fmt.Errorf("writing audit log failed: %v", err)
+	}
+
+	return nil
+}
+
+// auditAccount returns the record of the account for the audit log, the
+// password hash is never included.
+func auditAccount(a Account) accountRecord {
+	r := newAccountRecord(a)
+	r.PasswordHash = ""
+	return r
+}
+
+// recordAccount records the change of the account from cur to a. Changing the
+// address is recorded as a rename, and a new password hash only as
+// "password: changed".
+func (db *DB) recordAccount(cur, a Account) error {
+	before, err := newAuditValue(auditAccount(cur))
+	if err != nil {
+		return err
+	}
+
+	after, err := newAuditValue(auditAccount(a))
+	if err != nil {
+		return err
+	}
+
+	if cur.Password != a.Password {
+		after["password"] = "changed"
+	}
+
+	action := "update"
+	if cur.Username != a.Username || cur.Domain != a.Domain {
+		action = "rename"
+	}
+
+	return db.record(action, "mailbox "+cur.Username+"@"+cur.Domain, before, after)
+}
+
+// recordAlias records a change of an alias, cur is nil for new aliases and a
+// is nil for removed aliases.
+func (db *DB) recordAlias(action string, cur, a *Alias) error {
+	var (
+		object        string
+		before, after interface{}
+	)
+
+	if a != nil {
+		object = "alias " + a.Source() + " -> " + a.Destination()
+		after = newAliasRecord(*a)
+	}
+
+	if cur != nil {
+		object = "alias " + cur.Source() + " -> " + cur.Destination()
+		before = newAliasRecord(*cur)
+	}
+
+	return db.record(action, object, before, after)
+}
+
+// auditTLSPolicy returns the record of the TLS policy for the audit log.
+func auditTLSPolicy(p TLSPolicy) tlsPolicyRecord {
+	return newTLSPolicyRecord(p)
+}
+
+// writeAudit stores the entries in the table audit_log, or appends them to
+// the file.
+func (db *DB) writeAudit(entries []auditEntry) error {
+	if db.audit.filename != "" {
+		return appendAuditFile(db.audit.filename, entries)
+	}
+
+	for _, e := range entries {
+		_, err := db.exec("INSERT INTO audit_log ("+auditColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+			e.Time, e.User, e.Command, e.Action, e.Object, e.Before, e.After)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendAuditFile appends the entries as JSON lines to the file.
+func appendAuditFile(filename string, entries []auditEntry) error {
+	f, err := openAuditFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return writeAuditFile(f, entries)
+}
+
+// openAuditFile opens the file for appending entries, it is created if it
+// does not exist.
+func openAuditFile(filename string) (*os.File, error) {
+	return os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// writeAuditFile writes the entries as JSON lines to f and closes it.
+func writeAuditFile(f *os.File, entries []auditEntry) error {
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		err := enc.Encode(e)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// SetAuditFile configures the audit log to be written to the file instead of
+// the table audit_log.
+func (db *DB) SetAuditFile(filename string) {
+	db.audit.filename = filename
+}
+
+// AuditLog returns the entries from the audit log which were recorded at or
+// after since (if set) for objects containing object, the oldest first.
+func (db *DB) AuditLog(since time.Time, object string) ([]auditEntry, error) {
+	var start string
+	if !since.IsZero() {
+		start = since.UTC().Format(time.RFC3339)
+	}
+
+	if db.audit.filename != "" {
+		return readAuditFile(db.audit.filename, start, object)
+	}
+
+	var entries []auditEntry
+	err := db.selectRows(&entries, "SELECT "+auditColumns+" FROM audit_log WHERE created_at >= ? AND "+
+		db.dialect.LikeExpr("object")+" ORDER BY id", start, likeContains(object))
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// readAuditFile returns the matching entries from the file.
+func readAuditFile(filename, start, object string) ([]auditEntry, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var e auditEntry
+		err = json.Unmarshal(sc.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("%v: line %d: %v", filename, line, err)
+		}
+
+		if e.Time < start || !strings.Contains(strings.ToLower(e.Object), strings.ToLower(object)) {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, sc.Err()
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// change is a modification of the database planned by 'vmail apply'.
+type change struct {
+	Action string
+	Object string
+	Detail string
+
+	apply func(tx *DB) error
+}
+
+func (c change) String() string {
+	if c.Detail == "" {
+		return c.Action + " " + c.Object
+	}
+	return c.Action + " " + c.Object + " (" + c.Detail + ")"
+}
+
+// fieldChanges collects the differences between the old and new values of
+// an object for display.
+type fieldChanges []string
+
+func (f *fieldChanges) add(name string, before, after interface{}) {
+	if before != after {
+		*f = append(*f, fmt.Sprintf("%v %v -> %v", name, before, after))
+	}
+}
+
+func (f fieldChanges) String() string {
+	return strings.Join(f, ", ")
+}
+
+// applyPlan contains all changes needed to get from the current state of the
+// database to the state described by a document, in the order they need to
+// be applied.
+type applyPlan struct {
+	createDomains      []change
+	deleteAliasDomains []change
+	deleteAliases      []change
+	deleteAccounts     []change
+	domainLimits       []change
+	accounts           []change
+	aliases            []change
+	aliasDomains       []change
+	tlsPolicies        []change
+	updateDomains      []change
+	deleteDomains      []change
+}
+
+// Changes returns the list of changes.
+func (p applyPlan) Changes() []change {
+	var list []change
+	for _, changes := range [][]change{
+		p.createDomains,
+		p.deleteAliasDomains,
+		p.deleteAliases,
+		p.deleteAccounts,
+		p.domainLimits,
+		p.accounts,
+		p.aliases,
+		p.aliasDomains,
+		p.tlsPolicies,
+		p.updateDomains,
+		p.deleteDomains,
+	} {
+		list = append(list, changes...)
+	}
+	return list
+}
+
+// Apply makes all changes in the plan in the order returned by Changes. It
+// stops at the first error, tx should be a transaction so that it can be
+// rolled back.
+func (p applyPlan) Apply(tx *DB) error {
+	for _, c := range p.Changes() {
+		err := c.apply(tx)
+		if err != nil {
+			return fmt.Errorf("%v %v failed: %v", c.Action, c.Object, err)
+		}
+	}
+
+	return nil
+}
+
+// aliasKey identifies a row in the aliases table.
+type aliasKey struct {
+	Source      string
+	Destination string
+}
+
+// planApply computes the changes needed to make the database match doc. When
+// prune is set, objects not mentioned in doc are removed.
+func planApply(db *DB, doc Document, prune bool) (applyPlan, error) {
+	var plan applyPlan
+
+	current, err := db.FindAllDomains("")
+	if err != nil {
+		return applyPlan{}, err
+	}
+
+	existing := make(map[string]bool)
+	states := make(map[string]Domain)
+	limits := make(map[string]DomainLimits)
+	for _, d := range current {
+		existing[d.Domain] = true
+		states[d.Domain] = d
+		limits[d.Domain] = d.DomainLimits
+	}
+
+	desired := make(map[string]bool)
+	for _, d := range doc.Domains {
+		desired[d.Name] = true
+
+		if !existing[d.Name] {
+			name := d.Name
+			plan.createDomains = append(plan.createDomains, change{
+				Action: "create",
+				Object: "domain " + name,
+				apply: func(tx *DB) error {
+					return tx.CreateDomain(name)
+				},
+			})
+		}
+
+		// limits are changed before mailboxes and aliases are created, so
+		// that new mailboxes are checked against the new limits
+		if d.Limits != nil && d.Limits.DomainLimits() != limits[d.Name] {
+			name, cur, l := d.Name, limits[d.Name], d.Limits.DomainLimits()
+
+			var fields fieldChanges
+			fields.add("max mailboxes", cur.MaxAccounts, l.MaxAccounts)
+			fields.add("max aliases", cur.MaxAliases, l.MaxAliases)
+			fields.add("max quota", cur.MaxQuota, l.MaxQuota)
+			fields.add("default quota", cur.DefaultQuota, l.DefaultQuota)
+
+			plan.domainLimits = append(plan.domainLimits, change{
+				Action: "update",
+				Object: "domain " + name,
+				Detail: fields.String(),
+				apply: func(tx *DB) error {
+					return tx.SetDomainLimits(name, l)
+				},
+			})
+		}
+
+		// new domains are enabled and receive mail until all mailboxes and
+		// aliases are created
+		state, ok := states[d.Name]
+		if !ok {
+			state = Domain{Domain: d.Name, Enabled: true}
+		}
+
+		err = planApplyAccounts(db, &plan, d, existing[d.Name], state, prune)
+		if err != nil {
+			return applyPlan{}, err
+		}
+
+		err = planApplyAliases(db, &plan, d, existing[d.Name], state, prune)
+		if err != nil {
+			return applyPlan{}, err
+		}
+
+		if d.Enabled != nil && *d.Enabled != state.Enabled {
+			name, enabled := d.Name, *d.Enabled
+			plan.updateDomains = append(plan.updateDomains, change{
+				Action: "update",
+				Object: "domain " + name,
+				Detail: fmt.Sprintf("enabled %v -> %v", state.Enabled, enabled),
+				apply: func(tx *DB) error {
+					_, err := tx.SetDomainEnabled(name, enabled)
+					return err
+				},
+			})
+		}
+
+		if d.SendOnly != nil && *d.SendOnly != state.Sendonly {
+			name, sendonly := d.Name, *d.SendOnly
+			plan.updateDomains = append(plan.updateDomains, change{
+				Action: "update",
+				Object: "domain " + name,
+				Detail: fmt.Sprintf("send-only %v -> %v", state.Sendonly, sendonly),
+				apply: func(tx *DB) error {
+					_, err := tx.SetDomainSendonly(name, sendonly)
+					return err
+				},
+			})
+		}
+	}
+
+	if prune {
+		for _, d := range current {
+			if desired[d.Domain] {
+				continue
+			}
+
+			name := d.Domain
+			plan.deleteDomains = append(plan.deleteDomains, change{
+				Action: "delete",
+				Object: "domain " + name,
+				Detail: "including all mailboxes and aliases",
+				apply: func(tx *DB) error {
+					return tx.DeleteDomain(name)
+				},
+			})
+		}
+	}
+
+	err = planApplyAliasDomains(db, &plan, doc.AliasDomains, prune)
+	if err != nil {
+		return applyPlan{}, err
+	}
+
+	err = planApplyTLSPolicies(db, &plan, doc.TLSPolicies, prune)
+	if err != nil {
+		return applyPlan{}, err
+	}
+
+	return plan, nil
+}
+
+func planApplyAccounts(db *DB, plan *applyPlan, d DocumentDomain, exists bool, state Domain, prune bool) error {
+	var accounts []Account
+	if exists {
+		var err error
+		accounts, err = db.FindAllAccounts(d.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	current := make(map[string]Account)
+	for _, a := range accounts {
+		current[a.Username+"@"+a.Domain] = a
+	}
+
+	desired := make(map[string]bool)
+	for _, m := range d.Mailboxes {
+		desired[m.Address] = true
+		user, domain, err := splitMailAddress(m.Address)
+		if err != nil {
+			return err
+		}
+
+		cur, ok := current[m.Address]
+		if !ok {
+			if m.PasswordHash == "" {
+				return fmt.Errorf("mailbox %v: password_hash is required for new mailboxes", m.Address)
+			}
+
+			a := Account{
+				Username: user,
+				Domain:   domain,
+				Password: m.PasswordHash,
+				Enabled:  true,
+			}
+			if m.Quota != nil {
+				a.Quota = *m.Quota
+			}
+			if m.SendOnly != nil {
+				a.Sendonly = *m.SendOnly
+			}
+			if m.Enabled != nil {
+				a.Enabled = *m.Enabled
+			}
+
+			plan.accounts = append(plan.accounts, change{
+				Action: "create",
+				Object: "mailbox " + m.Address,
+				apply: func(tx *DB) error {
+					return tx.CreateAccount(a)
+				},
+			})
+			continue
+		}
+
+		a := cur
+		if m.PasswordHash != "" && m.PasswordHash != cur.Password {
+			a.Password = m.PasswordHash
+			a.PasswordReset = false
+		}
+		if m.Quota != nil {
+			a.Quota = *m.Quota
+		}
+		sendonly := cur.IsSendonly()
+		if m.SendOnly != nil {
+			sendonly = *m.SendOnly
+		}
+		a.Sendonly, a.DomainSendonly = sendonlyState(sendonly, state.Sendonly)
+
+		enabled := cur.IsEnabled()
+		if m.Enabled != nil {
+			enabled = *m.Enabled
+		}
+		a.Enabled, a.Suspended = suspendState(enabled, state.Enabled)
+
+		if a == cur {
+			continue
+		}
+
+		var fields fieldChanges
+		if a.Password != cur.Password {
+			fields = append(fields, "password hash changed")
+		}
+		fields.add("password reset", cur.PasswordReset, a.PasswordReset)
+		fields.add("quota", cur.Quota, a.Quota)
+		fields.add("enabled", cur.IsEnabled(), a.IsEnabled())
+		fields.add("send-only", cur.IsSendonly(), a.IsSendonly())
+
+		plan.accounts = append(plan.accounts, change{
+			Action: "update",
+			Object: "mailbox " + m.Address,
+			Detail: fields.String(),
+			apply: func(tx *DB) error {
+				return tx.UpdateAccount(a)
+			},
+		})
+	}
+
+	if prune {
+		for _, a := range accounts {
+			address := a.Username + "@" + a.Domain
+			if desired[address] {
+				continue
+			}
+
+			user, domain := a.Username, a.Domain
+			plan.deleteAccounts = append(plan.deleteAccounts, change{
+				Action: "delete",
+				Object: "mailbox " + address,
+				apply: func(tx *DB) error {
+					return tx.DeleteMailbox(user, domain)
+				},
+			})
+		}
+	}
+
+	return nil
+}
+
+func planApplyAliases(db *DB, plan *applyPlan, d DocumentDomain, exists bool, state Domain, prune bool) error {
+	var aliases []Alias
+	if exists {
+		var err error
+		aliases, err = db.FindAllAliases(d.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	current := make(map[aliasKey]Alias)
+	for _, a := range aliases {
+		current[aliasKey{a.Source(), a.Destination()}] = a
+	}
+
+	desired := make(map[aliasKey]bool)
+	for _, da := range d.Aliases {
+		srcuser, srcdomain, err := parseAliasSource(da.Source)
+		if err != nil {
+			return err
+		}
+
+		for _, dest := range da.Destinations {
+			dstuser, dstdomain, err := splitMailAddress(dest)
+			if err != nil {
+				return err
+			}
+
+			a := Alias{
+				SourceUsername:      srcuser,
+				SourceDomain:        srcdomain,
+				DestinationUsername: dstuser,
+				DestinationDomain:   dstdomain,
+				Blacklisted:         da.Blacklisted,
+			}
+			a.Enabled, a.Suspended = suspendState(da.IsEnabled(), state.receives())
+
+			key := aliasKey{a.Source(), a.Destination()}
+			if desired[key] {
+				return fmt.Errorf("alias %v -> %v listed more than once", key.Source, key.Destination)
+			}
+			desired[key] = true
+
+			object := "alias " + key.Source + " -> " + key.Destination
+
+			cur, ok := current[key]
+			if !ok {
+				plan.aliases = append(plan.aliases, change{
+					Action: "create",
+					Object: object,
+					apply: func(tx *DB) error {
+						return tx.CreateAlias(a)
+					},
+				})
+				continue
+			}
+
+			a.ID, a.Mirrored = cur.ID, cur.Mirrored
+			if a == cur {
+				continue
+			}
+
+			var fields fieldChanges
+			fields.add("blacklisted", cur.Blacklisted, a.Blacklisted)
+			fields.add("enabled", cur.IsEnabled(), a.IsEnabled())
+
+			plan.aliases = append(plan.aliases, change{
+				Action: "update",
+				Object: object,
+				Detail: fields.String(),
+				apply: func(tx *DB) error {
+					return tx.UpdateAlias(a)
+				},
+			})
+		}
+	}
+
+	if prune {
+		for _, a := range aliases {
+			key := aliasKey{a.Source(), a.Destination()}
+			if desired[key] || a.Mirrored {
+				continue
+			}
+
+			a := a
+			plan.deleteAliases = append(plan.deleteAliases, change{
+				Action: "delete",
+				Object: "alias " + key.Source + " -> " + key.Destination,
+				apply: func(tx *DB) error {
+					return tx.DeleteAlias(a.SourceUsername, a.SourceDomain, a.DestinationUsername, a.DestinationDomain)
+				},
+			})
+		}
+	}
+
+	return nil
+}
+
+// planApplyAliasDomains plans the changes for alias domains. Changing the
+// target of an alias domain is done by removing and creating it again, which
+// also updates all mirrored aliases. Alias domains are removed before
+// mailboxes are created, so that a former alias domain may get mailboxes.
+func planApplyAliasDomains(db *DB, plan *applyPlan, aliasDomains []DocumentAliasDomain, prune bool) error {
+	list, err := db.FindAllAliasDomains()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]AliasDomain)
+	for _, ad := range list {
+		current[ad.AliasDomain] = ad
+	}
+
+	desired := make(map[string]bool)
+	for _, dad := range aliasDomains {
+		desired[dad.Domain] = true
+		alias, target := dad.Domain, dad.Target
+
+		cur, ok := current[alias]
+		if ok && cur.TargetDomain == target {
+			continue
+		}
+
+		if ok {
+			plan.deleteAliasDomains = append(plan.deleteAliasDomains, change{
+				Action: "delete",
+				Object: "alias domain " + alias,
+				Detail: "target " + cur.TargetDomain,
+				apply: func(tx *DB) error {
+					return tx.DeleteAliasDomain(alias)
+				},
+			})
+		}
+
+		plan.aliasDomains = append(plan.aliasDomains, change{
+			Action: "create",
+			Object: "alias domain " + alias,
+			Detail: "target " + target,
+			apply: func(tx *DB) error {
+				return tx.CreateAliasDomain(alias, target)
+			},
+		})
+	}
+
+	if prune {
+		for _, ad := range list {
+			if desired[ad.AliasDomain] {
+				continue
+			}
+
+			alias := ad.AliasDomain
+			plan.deleteAliasDomains = append(plan.deleteAliasDomains, change{
+				Action: "delete",
+				Object: "alias domain " + alias,
+				Detail: "target " + ad.TargetDomain,
+				apply: func(tx *DB) error {
+					return tx.DeleteAliasDomain(alias)
+				},
+			})
+		}
+	}
+
+	return nil
+}
+
+func planApplyTLSPolicies(db *DB, plan *applyPlan, policies []DocumentTLSPolicy, prune bool) error {
+	list, err := db.FindAllTLSPolicies("")
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]TLSPolicy)
+	for _, p := range list {
+		current[p.Domain] = p
+	}
+
+	desired := make(map[string]bool)
+	for _, dp := range policies {
+		desired[dp.Domain] = true
+
+		p := TLSPolicy{
+			Domain: dp.Domain,
+			Policy: dp.Policy,
+			Params: sql.NullString{String: dp.Params, Valid: dp.Params != ""},
+		}
+
+		cur, ok := current[dp.Domain]
+		if !ok {
+			plan.tlsPolicies = append(plan.tlsPolicies, change{
+				Action: "create",
+				Object: "TLS policy " + dp.Domain,
+				Detail: strings.TrimSpace(p.Policy + " " + p.Params.String),
+				apply: func(tx *DB) error {
+					return tx.CreateTLSPolicy(p)
+				},
+			})
+			continue
+		}
+
+		p.ID = cur.ID
+		if p.Policy == cur.Policy && p.Params.String == cur.Params.String {
+			continue
+		}
+
+		var fields fieldChanges
+		fields.add("policy", cur.Policy, p.Policy)
+		fields.add("params", cur.Params.String, p.Params.String)
+
+		plan.tlsPolicies = append(plan.tlsPolicies, change{
+			Action: "update",
+			Object: "TLS policy " + dp.Domain,
+			Detail: fields.String(),
+			apply: func(tx *DB) error {
+				return tx.UpdateTLSPolicy(p)
+			},
+		})
+	}
+
+	if prune {
+		for _, p := range list {
+			if desired[p.Domain] {
+				continue
+			}
+
+			domain := p.Domain
+			plan.tlsPolicies = append(plan.tlsPolicies, change{
+				Action: "delete",
+				Object: "TLS policy " + domain,
+				apply: func(tx *DB) error {
+					return tx.DeleteTLSPolicy(domain)
+				},
+			})
+		}
+	}
+
+	return nil
+}
+
+var applyOpts = struct {
+	File  string
+	Prune bool
+}{}
+
+func init() {
+	cmdApply.Flags().StringVarP(&applyOpts.File, "file", "f", "", "read the desired state from `file` (YAML, JSON or TOML, '-' for stdin)")
+	cmdApply.Flags().BoolVar(&applyOpts.Prune, "prune", false, "remove domains, mailboxes, aliases and TLS policies not listed in the file")
+	root.AddCommand(cmdApply)
+}
+
+var cmdApply = &cobra.Command{
+	Use:   "apply [flags] -f file",
+	Short: "Change the database to match a declarative description",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if applyOpts.File == "" {
+			return errors.New("pass the file to apply with --file")
+		}
+
+		doc, err := readDocument(applyOpts.File, "")
+		if err != nil {
+			return err
+		}
+
+		err = doc.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid file %v:\n%v", applyOpts.File, err)
+		}
+
+		return opts.db.WithTx(func(tx *DB) error {
+			plan, err := planApply(tx, doc, applyOpts.Prune)
+			if err != nil {
+				return err
+			}
+
+			changes := plan.Changes()
+			if len(changes) == 0 {
+				msg("nothing to do")
+				return nil
+			}
+
+			for _, c := range changes {
+				msg("%v", c)
+			}
+
+			err = plan.Apply(tx)
+			if err != nil {
+				return err
+			}
+
+			if !opts.DryRun {
+				msg("%d changes applied", len(changes))
+			}
+			return nil
+		})
+	},
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testApplyDocument = `
+version: 1
+domains:
+  - name: example.com
+    mailboxes:
+      - address: admin@example.com
+        password_hash: "{SHA512-CRYPT}$6$rounds=50000$ulmVUEocA1Bat02X$QU5qy9UbKxw5CY3kTSX4qDi0H4CIjCZ7WzddrdmGNpLsmkThITCZZXDPHDAAVoHRdDhPz.2mTZXg66mi5SAmC0"
+        quota: 1000
+      - address: former@example.com
+        password_hash: "{SHA512-CRYPT}$6$rounds=50000$ulmVUEocA1Bat02X$QU5qy9UbKxw5CY3kTSX4qDi0H4CIjCZ7WzddrdmGNpLsmkThITCZZXDPHDAAVoHRdDhPz.2mTZXg66mi5SAmC0"
+        enabled: false
+    aliases:
+      - source: "*@example.com"
+        destinations: [admin@example.com]
+      - source: info@example.com
+        destinations: [admin@example.com, someone@example.org]
+tls_policies:
+  - domain: example.org
+    policy: secure
+    params: match=.example.org
+`
+
+func applyDocument(t testing.TB, db *DB, doc Document, prune bool) []change {
+	var changes []change
+	err := db.WithTx(func(tx *DB) error {
+		plan, err := planApply(tx, doc, prune)
+		if err != nil {
+			return err
+		}
+
+		changes = plan.Changes()
+		return plan.Apply(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return changes
+}
+
+func TestApply(t *testing.T) {
+	db := newTestDB(t)
+
+	doc, err := decodeDocument([]byte(testApplyDocument), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = doc.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := applyDocument(t, db, doc, false)
+	if len(changes) != 7 {
+		t.Fatalf("want 7 changes, got %d: %v", len(changes), changes)
+	}
+
+	// applying the same document again must not change anything
+	changes = applyDocument(t, db, doc, false)
+	if len(changes) != 0 {
+		t.Fatalf("want no changes for second run, got %v", changes)
+	}
+
+	// remove one destination and a mailbox, without prune nothing is removed
+	doc.Domains[0].Mailboxes = doc.Domains[0].Mailboxes[:1]
+	doc.Domains[0].Aliases[1].Destinations = doc.Domains[0].Aliases[1].Destinations[:1]
+
+	changes = applyDocument(t, db, doc, false)
+	if len(changes) != 0 {
+		t.Fatalf("want no changes without prune, got %v", changes)
+	}
+
+	changes = applyDocument(t, db, doc, true)
+	if len(changes) != 2 {
+		t.Fatalf("want 2 changes with prune, got %v", changes)
+	}
+
+	accounts, err := db.FindAllAccounts("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 1 || accounts[0].Username != "admin" || accounts[0].Quota != 1000 {
+		t.Fatalf("unexpected accounts after prune: %+v", accounts)
+	}
+}
+
+func TestApplyCommand(t *testing.T) {
+	db := useTestDB(t)
+
+	filename := filepath.Join(t.TempDir(), "mail.yaml")
+	err := ioutil.WriteFile(filename, []byte(testApplyDocument), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := applyOpts
+	applyOpts.File, applyOpts.Prune = filename, false
+	defer func() {
+		applyOpts = prev
+	}()
+
+	err = cmdApply.RunE(cmdApply, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := db.FindAllAccounts("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 2 {
+		t.Fatalf("want 2 mailboxes, got %+v", accounts)
+	}
+
+	policies, err := db.FindAllTLSPolicies("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policies) != 1 || policies[0].Policy != "secure" {
+		t.Fatalf("unexpected TLS policies %+v", policies)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdAudit = &cobra.Command{
+	Use:   "audit",
+	Short: "Check the database for problems",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("the 'audit' command needs to know what to check: passwords?")
+	},
+}
+
+// passwordAuditRecord is the result of checking the password hash of a
+// mailbox in machine-readable output.
+type passwordAuditRecord struct {
+	Address       string `json:"address" yaml:"address"`
+	Scheme        string `json:"scheme" yaml:"scheme"`
+	Params        string `json:"params" yaml:"params"`
+	Status        string `json:"status" yaml:"status"`
+	Reason        string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	PasswordReset bool   `json:"password_reset" yaml:"password_reset"`
+}
+
+func newPasswordAuditRecord(a Account, res hashAudit) passwordAuditRecord {
+	return passwordAuditRecord{
+		Address:       a.Username + "@" + a.Domain,
+		Scheme:        res.Scheme,
+		Params:        res.Params.String(),
+		Status:        res.Status,
+		Reason:        res.Reason,
+		PasswordReset: a.PasswordReset,
+	}
+}
+
+var auditPasswordsOpts = struct {
+	ForceReset bool
+}{}
+
+func init() {
+	cmdAuditPasswords.Flags().BoolVar(&auditPasswordsOpts.ForceReset, "force-reset", false, "mark all mailboxes with weak, legacy or invalid hashes for a password reset")
+	cmdAudit.AddCommand(cmdAuditPasswords)
+	root.AddCommand(cmdAudit)
+}
+
+var cmdAuditPasswords = &cobra.Command{
+	Use:   "passwords [flags] [domain]",
+	Short: "Find weak and legacy password hashes",
+	Long: `Find weak and legacy password hashes.
+
+The password hashes of all mailboxes (or only those in the domain) are
+classified by scheme and cost: The status is "ok" or "weak" (if the cost is
+lower than for new hashes) for schemes which are used for new passwords,
+"legacy" for other schemes vmail can verify (PLAIN, PLAIN-MD5 and MD5-CRYPT)
+and "invalid" for everything else.
+
+With --force-reset, all mailboxes which do not have the status "ok" are
+marked for a password reset. The mark is removed when the password is
+changed with 'vmail password'.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("pass at most one domain as parameter")
+		}
+
+		return opts.db.WithTx(func(tx *DB) error {
+			var domains []string
+			if len(args) == 1 {
+				d, err := tx.FindDomain(args[0])
+				if err != nil {
+					return fmt.Errorf("domain %v: %v", args[0], err)
+				}
+				domains = append(domains, d.Domain)
+			} else {
+				all, err := tx.FindAllDomains("")
+				if err != nil {
+					return err
+				}
+				for _, d := range all {
+					domains = append(domains, d.Domain)
+				}
+			}
+
+			var (
+				records []passwordAuditRecord
+				flagged []Account
+			)
+
+			for _, domain := range domains {
+				accounts, err := tx.FindAllAccounts(domain)
+				if err != nil {
+					return err
+				}
+
+				for _, a := range accounts {
+					res := auditHash(a.Password)
+					if res.Status != hashOK {
+						flagged = append(flagged, a)
+						if auditPasswordsOpts.ForceReset {
+							a.PasswordReset = true
+						}
+					}
+					records = append(records, newPasswordAuditRecord(a, res))
+				}
+			}
+
+			// mailboxes which are already marked are not counted
+			marked := 0
+			if auditPasswordsOpts.ForceReset {
+				for _, a := range flagged {
+					if a.PasswordReset {
+						continue
+					}
+
+					err := tx.MarkPasswordReset(a.Username, a.Domain)
+					if err != nil {
+						return fmt.Errorf("marking mailbox %v@%v failed: %v", a.Username, a.Domain, err)
+					}
+					marked++
+				}
+			}
+
+			if machineOutput() {
+				if records == nil {
+					records = []passwordAuditRecord{}
+				}
+				return writeOutput(os.Stdout, records)
+			}
+
+			if len(records) > 0 {
+				t := newColoredTable()
+				t.AddColumn(" Mailbox ", " {{ .Address }} ")
+				t.AddColumn(" Scheme ", " {{ .Scheme }} ")
+				t.AddColumn(" Parameters ", " {{ .Params }} ")
+				t.AddColumn(" Status ", " {{ .Status }}{{ if .Reason }} ({{ .Reason }}){{ end }} ")
+				t.AddColumn(" Reset ", " {{ .PasswordReset }} ")
+
+				for _, r := range records {
+					t.AddRow(r)
+				}
+
+				err := t.Write(os.Stdout)
+				if err != nil {
+					return err
+				}
+			}
+
+			msg("%d of %d mailboxes need a new password hash", len(flagged), len(records))
+			if auditPasswordsOpts.ForceReset && len(flagged) > 0 {
+				msg("%d mailboxes marked for a password reset", marked)
+			}
+
+			return nil
+		})
+	},
+}
@@ -1,61 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
-	"github.com/ncw/pwhash/sha512_crypt"
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
-func readPassword() (string, error) {
-	fmt.Printf("enter password: ")
-	buf, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return "", err
-	}
-
-	fmt.Printf("\n")
-	fmt.Printf("repeat password: ")
-	buf2, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return "", err
-	}
-	fmt.Printf("\n")
-
-	if !bytes.Equal(buf, buf2) {
-		return "", errors.New("passwords do not match")
-	}
-
-	if len(buf) < 8 {
-		return "", errors.New("password is way too short")
-	}
-
-	return string(buf), nil
-}
-
-const (
-	hashRounds  = sha512_crypt.RoundsDefault * 10
-	hashSaltLen = 20
-)
-
-func hashPassword(pw string) string {
-	salt := sha512_crypt.GenerateSalt(hashSaltLen, hashRounds)
-	return "{SHA512-CRYPT}" + sha512_crypt.Crypt(pw, salt)
-}
-
-func checkHash(hash string) error {
-	if !strings.HasPrefix(hash, "{SHA512-CRYPT}$6$") {
-		return errors.New("hash is invalid (does not start with '{SHA512-CRYPT}$6$')")
-	}
-	return nil
-}
-
 func splitMailAddress(s string) (string, string, error) {
 	data := strings.SplitN(s, "@", -1)
 	if len(data) != 2 {
@@ -73,11 +26,27 @@ func splitMailAddress(s string) (string, string, error) {
 	return user, domain, nil
 }
 
+// parseAliasSource splits the source address of an alias, the local part
+// "*" (as in "*@example.com") is returned as NULL and selects the catch-all
+// alias for the domain.
+func parseAliasSource(s string) (sql.NullString, string, error) {
+	user, domain, err := splitMailAddress(s)
+	if err != nil {
+		return sql.NullString{}, "", err
+	}
+
+	if user == "*" {
+		return sql.NullString{}, domain, nil
+	}
+
+	return sql.NullString{String: user, Valid: true}, domain, nil
+}
+
 var cmdCreate = &cobra.Command{
 	Use:   "create",
 	Short: "Create domains, accounts, and aliases",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.New("the 'create' command needs to know what to create: domain, mailbox or alias?")
+		return errors.New("the 'create' command needs to know what to create: domain, domain-alias, mailbox or alias?")
 	},
 }
 
@@ -99,19 +68,39 @@ var cmdCreateDomain = &cobra.Command{
 	},
 }
 
+var cmdCreateDomainAlias = &cobra.Command{
+	Use:   "domain-alias [flags] alias target",
+	Short: "Redirect all addresses in a domain to a target domain",
+	Long: `Redirect all addresses in the domain alias to the same addresses in the
+domain target, e.g. mail to user@example.de is delivered to user@example.com.
+
+An alias is maintained in the alias domain for each mailbox and alias in the
+target domain. The alias domain is created if it does not exist, it must not
+contain any mailboxes.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("pass the alias domain and the target domain as parameters")
+		}
+
+		alias, target := args[0], args[1]
+		err := opts.db.CreateAliasDomain(alias, target)
+		if err != nil {
+			return fmt.Errorf("creating alias domain %v failed: %v", alias, err)
+		}
+		msg("domain %v is now an alias for %v", alias, target)
+		return nil
+	},
+}
+
 var createMailboxOpts = struct {
-	Quota           uint64
-	PasswordHash    string
-	Password        string
-	RawPasswordHash bool
-	SendOnly        bool
+	passwordFlags
+	Quota    uint64
+	SendOnly bool
 }{}
 
 func init() {
-	cmdCreateMailbox.Flags().Uint64Var(&createMailboxOpts.Quota, "quota", 0, "grant this mailbox `bytes` ")
-	cmdCreateMailbox.Flags().StringVar(&createMailboxOpts.Password, "password", "", "use `pwd` as the password")
-	cmdCreateMailbox.Flags().StringVar(&createMailboxOpts.PasswordHash, "password-hash", "", "use `hash` as the password (already hashed)")
-	cmdCreateMailbox.Flags().BoolVar(&createMailboxOpts.RawPasswordHash, "raw-password-hash", false, "do not check password hash")
+	cmdCreateMailbox.Flags().Uint64Var(&createMailboxOpts.Quota, "quota", 0, "grant this mailbox `bytes` (default: the default quota of the domain, then the quota from the profile)")
+	createMailboxOpts.passwordFlags.register(cmdCreateMailbox.Flags())
 	cmdCreateMailbox.Flags().BoolVar(&createMailboxOpts.SendOnly, "send-only", false, "do not receive mail for this account")
 }
 
@@ -129,23 +118,17 @@ var cmdCreateMailbox = &cobra.Command{
 			return err
 		}
 
-		var pwhash = createMailboxOpts.PasswordHash
-		if pwhash == "" {
-			var pw = createMailboxOpts.Password
-			if pw == "" && createMailboxOpts.PasswordHash == "" {
-				pw, err = readPassword()
-				if err != nil {
-					return err
-				}
-			}
-
-			pwhash = hashPassword(pw)
+		pwhash, generated, err := createMailboxOpts.passwordHash(user, domain)
+		if err != nil {
+			return err
 		}
 
-		if !createMailboxOpts.RawPasswordHash {
-			err = checkHash(pwhash)
-			if err != nil {
-				return err
+		// the quota from the profile is only used for domains without a
+		// default quota, CreateAccount applies the default quota otherwise
+		quota := int(createMailboxOpts.Quota)
+		if !cmd.Flags().Changed("quota") {
+			if d, err := opts.db.FindDomain(domain); err == nil && d.DefaultQuota > 0 {
+				quota = 0
 			}
 		}
 
@@ -154,7 +137,7 @@ var cmdCreateMailbox = &cobra.Command{
 			Username: user,
 			Password: pwhash,
 			Enabled:  true,
-			Quota:    int(createMailboxOpts.Quota),
+			Quota:    quota,
 			Sendonly: createMailboxOpts.SendOnly,
 		})
 
@@ -162,7 +145,7 @@ var cmdCreateMailbox = &cobra.Command{
 			return fmt.Errorf("creating mailbox %v failed: %v", mailbox, err)
 		}
 		msg("mailbox %v created", mailbox)
-		return nil
+		return createMailboxOpts.reveal(mailbox, generated)
 	},
 }
 
@@ -174,43 +157,38 @@ var cmdCreateAlias = &cobra.Command{
 			return errors.New("pass source and destinations")
 		}
 
-		var (
-			srcuser   sql.NullString
-			srcdomain string
-		)
-
-		srcuser.String, srcdomain, err = splitMailAddress(args[0])
+		srcuser, srcdomain, err := parseAliasSource(args[0])
 		if err != nil {
 			return err
 		}
 
-		// handle catchall alias
-		if srcuser.String == "*" {
-			srcuser.String = ""
-			srcuser.Valid = false
-		} else {
-			srcuser.Valid = true
-		}
+		// create all destinations or none
+		err = opts.db.WithTx(func(tx *DB) error {
+			for _, dest := range args[1:] {
+				dstuser, dstdomain, err := splitMailAddress(dest)
+				if err != nil {
+					return err
+				}
 
-		for _, dest := range args[1:] {
-			dstuser, dstdomain, err := splitMailAddress(dest)
-			if err != nil {
-				return err
-			}
+				err = tx.CreateAlias(Alias{
+					SourceUsername:      srcuser,
+					SourceDomain:        srcdomain,
+					DestinationUsername: dstuser,
+					DestinationDomain:   dstdomain,
+					Blacklisted:         false,
+					Enabled:             true,
+				})
 
-			err = opts.db.CreateAlias(Alias{
-				SourceUsername:      srcuser,
-				SourceDomain:        srcdomain,
-				DestinationUsername: dstuser,
-				DestinationDomain:   dstdomain,
-				Blacklisted:         false,
-				Enabled:             true,
-			})
-
-			if err != nil {
-				return fmt.Errorf("creating alias %v@%v -> %v@%v failed: %v",
-					srcuser.String, srcdomain, dstuser, dstdomain, err)
+				if err != nil {
+					return fmt.Errorf("creating alias %v -> %v@%v failed: %v",
+						args[0], dstuser, dstdomain, err)
+				}
 			}
+
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
 		msg("alias created successfully")
@@ -220,6 +198,7 @@ var cmdCreateAlias = &cobra.Command{
 
 func init() {
 	cmdCreate.AddCommand(cmdCreateDomain)
+	cmdCreate.AddCommand(cmdCreateDomainAlias)
 	cmdCreate.AddCommand(cmdCreateMailbox)
 	cmdCreate.AddCommand(cmdCreateAlias)
 	root.AddCommand(cmdCreate)
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestCreateAliasRollback(t *testing.T) {
+	db := useTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the third destination is invalid, so the first two must not be created
+	err = cmdCreateAlias.RunE(cmdCreateAlias, []string{"info@example.com",
+		"one@example.com", "two@example.com", "invalid"})
+	if err == nil {
+		t.Fatal("creating an alias with an invalid destination succeeded")
+	}
+
+	aliases, err := db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 0 {
+		t.Fatalf("want no aliases after the error, got %v", aliases)
+	}
+
+	// the second destination already exists
+	err = db.CreateAlias(Alias{
+		SourceUsername:      sql.NullString{String: "info", Valid: true},
+		SourceDomain:        "example.com",
+		DestinationUsername: "two",
+		DestinationDomain:   "example.com",
+		Enabled:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmdCreateAlias.RunE(cmdCreateAlias, []string{"info@example.com",
+		"one@example.com", "two@example.com"})
+	if err == nil {
+		t.Fatal("creating an existing alias succeeded")
+	}
+
+	aliases, err = db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0].DestinationUsername != "two" {
+		t.Fatalf("want only the existing alias after the error, got %v", aliases)
+	}
+}
+
+func TestCreateMailboxQuota(t *testing.T) {
+	db := useTestDB(t)
+
+	for _, name := range []string{"default.example", "plain.example"} {
+		err := db.CreateDomain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.SetDomainLimits("default.example", DomainLimits{DefaultQuota: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profileQuota := uint64(500)
+	p := Profile{Quota: &profileQuota}
+
+	// the order of precedence is flag, default quota of the domain, profile
+	var tests = []struct {
+		address string
+		flags   map[string]string
+		quota   int
+	}{
+		{"a@default.example", nil, 300},
+		{"b@plain.example", nil, 500},
+		{"c@default.example", map[string]string{"quota": "700"}, 700},
+		{"d@plain.example", map[string]string{"quota": "700"}, 700},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			flags := map[string]string{"password-hash": testPasswordHash}
+			for name, value := range test.flags {
+				flags[name] = value
+			}
+			setFlags(t, cmdCreateMailbox, flags)
+
+			err := p.apply(cmdCreateMailbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cmdCreateMailbox.RunE(cmdCreateMailbox, []string{test.address})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user, domain, _ := splitMailAddress(test.address)
+			a, err := db.FindAccount(user, domain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a.Quota != test.quota {
+				t.Errorf("want quota %d, got %d", test.quota, a.Quota)
+			}
+		})
+	}
+}
@@ -16,6 +16,14 @@ var cmdDelete = &cobra.Command{
 	},
 }
 
+var deleteDomainOpts = struct {
+	Yes bool
+}{}
+
+func init() {
+	cmdDeleteDomain.Flags().BoolVarP(&deleteDomainOpts.Yes, "yes", "y", false, "do not ask for confirmation")
+}
+
 var cmdDeleteDomain = &cobra.Command{
 	Use:   "domain [flags] name",
 	Short: "Delete a domain",
@@ -25,16 +33,55 @@ var cmdDeleteDomain = &cobra.Command{
 		}
 
 		name := args[0]
-		err := opts.db.DeleteDomain(name)
+		cascade, err := opts.db.PreviewDeleteDomain(name)
 		if err != nil {
-			warn("error deleting domain %v: %v", name, err)
-			return nil
+			return fmt.Errorf("deleting domain %v failed: %v", name, err)
+		}
+
+		msg("deleting domain %v removes:", name)
+		msg("  %d mailboxes", cascade.Mailboxes)
+		msg("  %d aliases in %v", cascade.Aliases, name)
+		msg("  %d aliases in other domains pointing to %v", cascade.ForeignAliases, name)
+
+		if !deleteDomainOpts.Yes && !opts.DryRun {
+			ok, err := confirm("delete domain %v?", name)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				msg("aborted")
+				return nil
+			}
+		}
+
+		err = opts.db.DeleteDomain(name)
+		if err != nil {
+			return fmt.Errorf("deleting domain %v failed: %v", name, err)
 		}
 		msg("domain %v deleted", name)
 		return nil
 	},
 }
 
+var cmdDeleteDomainAlias = &cobra.Command{
+	Use:   "domain-alias [flags] alias",
+	Short: "Stop redirecting an alias domain (the domain itself is kept)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("pass the alias domain as parameter")
+		}
+
+		name := args[0]
+		err := opts.db.DeleteAliasDomain(name)
+		if err != nil {
+			return fmt.Errorf("deleting alias domain %v failed: %v", name, err)
+		}
+		msg("alias domain %v deleted", name)
+		return nil
+	},
+}
+
 var cmdDeleteMailbox = &cobra.Command{
 	Use:   "mailbox [flags] name",
 	Short: "Delete a mailbox",
@@ -93,18 +140,25 @@ var cmdDeleteAlias = &cobra.Command{
 					srcusername, srcdomain, err)
 			}
 		} else {
-			// delete specified destinations
-			for _, dest := range args[1:] {
-				dstuser, dstdomain, err := splitMailAddress(dest)
-				if err != nil {
-					return err
+			// delete all specified destinations or none
+			err = opts.db.WithTx(func(tx *DB) error {
+				for _, dest := range args[1:] {
+					dstuser, dstdomain, err := splitMailAddress(dest)
+					if err != nil {
+						return err
+					}
+
+					err = tx.DeleteAlias(srcuser, srcdomain, dstuser, dstdomain)
+					if err != nil {
+						return fmt.Errorf("delete alias %v@%v -> %v@%v failed: %v",
+							srcusername, srcdomain, dstuser, dstdomain, err)
+					}
 				}
 
-				err = opts.db.DeleteAlias(srcuser, srcdomain, dstuser, dstdomain)
-				if err != nil {
-					return fmt.Errorf("delete alias %v@%v -> %v@%v failed: %v",
-						srcusername, srcdomain, dstuser, dstdomain, err)
-				}
+				return nil
+			})
+			if err != nil {
+				return err
 			}
 		}
 
@@ -115,6 +169,7 @@ var cmdDeleteAlias = &cobra.Command{
 
 func init() {
 	cmdDelete.AddCommand(cmdDeleteDomain)
+	cmdDelete.AddCommand(cmdDeleteDomainAlias)
 	cmdDelete.AddCommand(cmdDeleteMailbox)
 	cmdDelete.AddCommand(cmdDeleteAlias)
 	root.AddCommand(cmdDelete)
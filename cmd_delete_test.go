@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestDeleteAliasRollback(t *testing.T) {
+	db := useTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dest := range []string{"one", "two"} {
+		err = db.CreateAlias(Alias{
+			SourceUsername:      sql.NullString{String: "info", Valid: true},
+			SourceDomain:        "example.com",
+			DestinationUsername: dest,
+			DestinationDomain:   "example.com",
+			Enabled:             true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the last destination does not exist, so nothing must be deleted
+	err = cmdDeleteAlias.RunE(cmdDeleteAlias, []string{"info@example.com",
+		"one@example.com", "two@example.com", "three@example.com"})
+	if err == nil {
+		t.Fatal("deleting a missing destination succeeded")
+	}
+
+	aliases, err := db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 2 {
+		t.Fatalf("want both aliases after the error, got %v", aliases)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +21,37 @@ func init() {
 				return err
 			}
 
+			if machineOutput() {
+				records := make([]domainRecord, 0, len(domains))
+				for _, d := range domains {
+					records = append(records, newDomainRecord(d))
+				}
+				return writeOutput(os.Stdout, records)
+			}
+
+			aliasDomains, err := opts.db.FindAllAliasDomains()
+			if err != nil {
+				return err
+			}
+
+			targets := make(map[string]string)
+			for _, ad := range aliasDomains {
+				targets[ad.AliasDomain] = ad.TargetDomain
+			}
+
 			for _, d := range domains {
-				msg("%v\n", d.Domain)
+				if target, ok := targets[d.Domain]; ok {
+					d.Domain += " (alias for " + target + ")"
+				}
+
+				switch {
+				case !d.Enabled:
+					msg("%v (disabled)", d.Domain)
+				case d.Sendonly:
+					msg("%v (send-only)", d.Domain)
+				default:
+					msg("%v\n", d.Domain)
+				}
 			}
 
 			return nil
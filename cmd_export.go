@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// exportDocument returns a document describing all domains, mailboxes,
+// aliases, alias domains and TLS policies in the database. Aliases
+// maintained for alias domains are not included.
+func exportDocument(db *DB) (Document, error) {
+	doc := Document{Version: documentVersion}
+
+	domains, err := db.FindAllDomains("")
+	if err != nil {
+		return Document{}, err
+	}
+
+	for _, d := range domains {
+		domainEnabled, domainSendonly := d.Enabled, d.Sendonly
+		dd := DocumentDomain{Name: d.Domain, Enabled: &domainEnabled, SendOnly: &domainSendonly}
+
+		if d.DomainLimits != (DomainLimits{}) {
+			dd.Limits = &DocumentLimits{
+				MaxMailboxes: d.MaxAccounts,
+				MaxAliases:   d.MaxAliases,
+				MaxQuota:     d.MaxQuota,
+				DefaultQuota: d.DefaultQuota,
+			}
+		}
+
+		accounts, err := db.FindAllAccounts(d.Domain)
+		if err != nil {
+			return Document{}, err
+		}
+
+		// suspended mailboxes and aliases are exported as enabled, they are
+		// suspended again when the domain is disabled, and mailboxes are only
+		// exported as send-only when they are send-only by themselves
+		for _, a := range accounts {
+			quota, sendOnly, enabled := a.Quota, a.IsSendonly(), a.IsEnabled()
+			dd.Mailboxes = append(dd.Mailboxes, DocumentMailbox{
+				Address:      a.Username + "@" + a.Domain,
+				PasswordHash: a.Password,
+				Quota:        &quota,
+				SendOnly:     &sendOnly,
+				Enabled:      &enabled,
+			})
+		}
+
+		aliases, err := db.FindAllAliases(d.Domain)
+		if err != nil {
+			return Document{}, err
+		}
+
+		// destinations with the same source and flags are grouped together
+		type group struct {
+			Source      string
+			Blacklisted bool
+			Enabled     bool
+		}
+		index := make(map[group]int)
+
+		for _, a := range aliases {
+			if a.Mirrored {
+				continue
+			}
+
+			g := group{a.Source(), a.Blacklisted, a.IsEnabled()}
+			i, ok := index[g]
+			if !ok {
+				enabled := g.Enabled
+				dd.Aliases = append(dd.Aliases, DocumentAlias{
+					Source:      g.Source,
+					Blacklisted: g.Blacklisted,
+					Enabled:     &enabled,
+				})
+				i = len(dd.Aliases) - 1
+				index[g] = i
+			}
+
+			dd.Aliases[i].Destinations = append(dd.Aliases[i].Destinations, a.Destination())
+		}
+
+		doc.Domains = append(doc.Domains, dd)
+	}
+
+	aliasDomains, err := db.FindAllAliasDomains()
+	if err != nil {
+		return Document{}, err
+	}
+
+	for _, ad := range aliasDomains {
+		doc.AliasDomains = append(doc.AliasDomains, DocumentAliasDomain{
+			Domain: ad.AliasDomain,
+			Target: ad.TargetDomain,
+		})
+	}
+
+	policies, err := db.FindAllTLSPolicies("")
+	if err != nil {
+		return Document{}, err
+	}
+
+	for _, p := range policies {
+		doc.TLSPolicies = append(doc.TLSPolicies, DocumentTLSPolicy{
+			Domain: p.Domain,
+			Policy: p.Policy,
+			Params: p.Params.String,
+		})
+	}
+
+	return doc, nil
+}
+
+var exportOpts = struct {
+	File   string
+	Format string
+}{}
+
+func init() {
+	cmdExport.Flags().StringVarP(&exportOpts.File, "file", "f", "", "write to `file` instead of stdout")
+	cmdExport.Flags().StringVar(&exportOpts.Format, "format", "", "write the document as `json`, yaml or toml (default: from the file extension or yaml)")
+	root.AddCommand(cmdExport)
+}
+
+var cmdExport = &cobra.Command{
+	Use:   "export [flags]",
+	Short: "Write all domains, mailboxes, aliases and TLS policies to a file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := exportOpts.Format
+		if format == "" {
+			format = documentFormat(exportOpts.File)
+		}
+
+		doc, err := exportDocument(opts.db)
+		if err != nil {
+			return err
+		}
+
+		buf, err := encodeDocument(doc, format)
+		if err != nil {
+			return err
+		}
+
+		if exportOpts.File == "" || exportOpts.File == "-" {
+			_, err = os.Stdout.Write(buf)
+			return err
+		}
+
+		// the document contains password hashes
+		return ioutil.WriteFile(exportOpts.File, buf, 0600)
+	},
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// importErrors collects all problems found in the data to import.
+type importErrors []string
+
+func (e *importErrors) add(s string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(s, args...))
+}
+
+func (e importErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(e, "\n"))
+}
+
+// csvColumns are the columns of a CSV file, only address and password are
+// required.
+var csvColumns = []string{"address", "password", "quota", "send_only"}
+
+// readMailboxCSV parses a CSV file with mailboxes, one per record. The
+// password column contains either a cleartext password (which is hashed) or
+// a password hash. A first line starting with "address" is ignored, as are
+// empty lines and lines starting with #. Cleartext passwords are hashed with
+// scheme. It returns a document, the line number for each address and the
+// problems found in the file.
+func readMailboxCSV(rd io.Reader, scheme string) (Document, map[string]int, importErrors, error) {
+	var (
+		errs    importErrors
+		doc     = Document{Version: documentVersion}
+		lines   = make(map[string]int)
+		domains = make(map[string]int)
+	)
+
+	r := csv.NewReader(rd)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			errs.add("line %d: %v", perr.StartLine, perr.Err)
+			continue
+		}
+
+		if err != nil {
+			return Document{}, nil, nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		if line == 1 && strings.EqualFold(record[0], csvColumns[0]) {
+			// header
+			continue
+		}
+
+		if len(record) < 2 || len(record) > len(csvColumns) {
+			errs.add("line %d: want %d to %d columns (%v), got %d",
+				line, 2, len(csvColumns), strings.Join(csvColumns, ", "), len(record))
+			continue
+		}
+
+		m, err := parseMailboxRecord(record, scheme)
+		if err != nil {
+			errs.add("line %d: %v", line, err)
+			continue
+		}
+
+		if prev, ok := lines[m.Address]; ok {
+			errs.add("line %d: mailbox %v already listed in line %d", line, m.Address, prev)
+			continue
+		}
+		lines[m.Address] = line
+
+		_, domain, _ := splitMailAddress(m.Address)
+		i, ok := domains[domain]
+		if !ok {
+			doc.Domains = append(doc.Domains, DocumentDomain{Name: domain})
+			i = len(doc.Domains) - 1
+			domains[domain] = i
+		}
+		doc.Domains[i].Mailboxes = append(doc.Domains[i].Mailboxes, m)
+	}
+
+	return doc, lines, errs, nil
+}
+
+// parseMailboxRecord checks the fields of a line from the CSV file with the
+// same rules as 'vmail create mailbox'. Empty or missing columns for the
+// quota and send-only are not set in the returned mailbox, so they are not
+// changed for existing mailboxes.
+func parseMailboxRecord(record []string, scheme string) (DocumentMailbox, error) {
+	var m DocumentMailbox
+
+	user, domain, err := splitMailAddress(record[0])
+	if err != nil {
+		return DocumentMailbox{}, err
+	}
+	m.Address = record[0]
+
+	pw := record[1]
+	if strings.HasPrefix(pw, "{") {
+		err = checkHash(pw)
+		if err != nil {
+			return DocumentMailbox{}, err
+		}
+		m.PasswordHash = pw
+	} else {
+		err = checkPassword(pw, user, domain)
+		if err != nil {
+			return DocumentMailbox{}, err
+		}
+		m.PasswordHash, err = hashPassword(scheme, pw)
+		if err != nil {
+			return DocumentMailbox{}, err
+		}
+	}
+
+	if len(record) > 2 && record[2] != "" {
+		quota, err := strconv.Atoi(record[2])
+		if err != nil || quota < 0 {
+			return DocumentMailbox{}, fmt.Errorf("invalid quota %q", record[2])
+		}
+		m.Quota = &quota
+	}
+
+	if len(record) > 3 && record[3] != "" {
+		sendOnly, err := strconv.ParseBool(record[3])
+		if err != nil {
+			return DocumentMailbox{}, fmt.Errorf("invalid value for send_only %q", record[3])
+		}
+		m.SendOnly = &sendOnly
+	}
+
+	return m, nil
+}
+
+// How to handle objects which are already present in the database.
+const (
+	importExistingFail = iota
+	importExistingSkip
+	importExistingUpdate
+)
+
+// filterExisting checks all mailboxes, aliases and TLS policies in doc
+// against the database. Depending on policy, an error is reported for each
+// one already present, or they are removed from the returned document, or
+// they are kept so that they are updated. The settings of existing domains
+// are only kept for importExistingUpdate. When requireDomains is set, all
+// domains must already exist. locate returns the position of an object in
+// the input for error messages.
+func filterExisting(db *DB, doc Document, policy int, requireDomains bool, locate func(object string) string) (Document, importErrors, error) {
+	var errs importErrors
+
+	existing := func(object string) (keep bool) {
+		switch policy {
+		case importExistingSkip:
+			msg("skipping existing %v", object)
+			return false
+		case importExistingUpdate:
+			return true
+		}
+
+		errs.add("%v%v already exists", locate(object), object)
+		return false
+	}
+
+	var domains []DocumentDomain
+	for _, d := range doc.Domains {
+		_, err := db.FindDomain(d.Name)
+		if err != nil {
+			if requireDomains {
+				for _, m := range d.Mailboxes {
+					errs.add("%vdomain %v does not exist", locate("mailbox "+m.Address), d.Name)
+				}
+			}
+
+			// nothing can exist in a new domain
+			domains = append(domains, d)
+			continue
+		}
+
+		if policy != importExistingUpdate {
+			d.Enabled, d.SendOnly, d.Limits = nil, nil, nil
+		}
+
+		accounts, err := db.FindAllAccounts(d.Name)
+		if err != nil {
+			return Document{}, nil, err
+		}
+
+		present := make(map[string]bool)
+		for _, a := range accounts {
+			present[a.Username+"@"+a.Domain] = true
+		}
+
+		var mailboxes []DocumentMailbox
+		for _, m := range d.Mailboxes {
+			if present[m.Address] && !existing("mailbox "+m.Address) {
+				continue
+			}
+			mailboxes = append(mailboxes, m)
+		}
+		d.Mailboxes = mailboxes
+
+		aliases, err := db.FindAllAliases(d.Name)
+		if err != nil {
+			return Document{}, nil, err
+		}
+
+		presentAliases := make(map[aliasKey]bool)
+		for _, a := range aliases {
+			presentAliases[aliasKey{a.Source(), a.Destination()}] = true
+		}
+
+		var docAliases []DocumentAlias
+		for _, a := range d.Aliases {
+			var destinations []string
+			for _, dest := range a.Destinations {
+				if presentAliases[aliasKey{a.Source, dest}] && !existing("alias "+a.Source+" -> "+dest) {
+					continue
+				}
+				destinations = append(destinations, dest)
+			}
+
+			if len(destinations) > 0 {
+				a.Destinations = destinations
+				docAliases = append(docAliases, a)
+			}
+		}
+		d.Aliases = docAliases
+
+		domains = append(domains, d)
+	}
+	doc.Domains = domains
+
+	aliasDomains, err := db.FindAllAliasDomains()
+	if err != nil {
+		return Document{}, nil, err
+	}
+
+	presentAliasDomains := make(map[string]bool)
+	for _, ad := range aliasDomains {
+		presentAliasDomains[ad.AliasDomain] = true
+	}
+
+	var docAliasDomains []DocumentAliasDomain
+	for _, ad := range doc.AliasDomains {
+		if presentAliasDomains[ad.Domain] && !existing("alias domain "+ad.Domain) {
+			continue
+		}
+		docAliasDomains = append(docAliasDomains, ad)
+	}
+	doc.AliasDomains = docAliasDomains
+
+	policies, err := db.FindAllTLSPolicies("")
+	if err != nil {
+		return Document{}, nil, err
+	}
+
+	presentPolicies := make(map[string]bool)
+	for _, p := range policies {
+		presentPolicies[p.Domain] = true
+	}
+
+	var tlsPolicies []DocumentTLSPolicy
+	for _, p := range doc.TLSPolicies {
+		if presentPolicies[p.Domain] && !existing("TLS policy "+p.Domain) {
+			continue
+		}
+		tlsPolicies = append(tlsPolicies, p)
+	}
+	doc.TLSPolicies = tlsPolicies
+
+	return doc, errs, nil
+}
+
+var importOpts = struct {
+	Format         string
+	SkipExisting   bool
+	UpdateExisting bool
+	Scheme         string
+}{}
+
+func init() {
+	cmdImport.Flags().StringVar(&importOpts.Format, "format", "", "read the file as `csv`, json, yaml or toml (default: from the file extension)")
+	cmdImport.Flags().BoolVar(&importOpts.SkipExisting, "skip-existing", false, "ignore mailboxes, aliases and TLS policies which already exist")
+	cmdImport.Flags().BoolVar(&importOpts.UpdateExisting, "update-existing", false, "update mailboxes, aliases and TLS policies which already exist")
+	cmdImport.Flags().StringVar(&importOpts.Scheme, "scheme", defaultHashScheme, "hash cleartext passwords with `scheme` (SHA512-CRYPT, SHA256-CRYPT, BLF-CRYPT, ARGON2ID)")
+	root.AddCommand(cmdImport)
+}
+
+var cmdImport = &cobra.Command{
+	Use:   "import [flags] file",
+	Short: "Create mailboxes from a CSV file, or everything from an exported document",
+	Long: `Create mailboxes from a CSV file, or everything from an exported document.
+
+The CSV file contains one mailbox per line with the columns address,
+password, quota and send_only. The password is either the cleartext password
+or a hash. Only the address and the password are required, a first line
+starting with "address" is ignored. The domains must already exist. With
+--update-existing, empty or missing columns keep their current values.
+
+All data is checked before the database is modified and then imported in one
+transaction. By default, the import fails if a mailbox, alias or TLS policy
+already exists.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("pass the file to import as parameter ('-' for stdin)")
+		}
+
+		policy := importExistingFail
+		switch {
+		case importOpts.SkipExisting && importOpts.UpdateExisting:
+			return errors.New("--skip-existing and --update-existing are mutually exclusive")
+		case importOpts.SkipExisting:
+			policy = importExistingSkip
+		case importOpts.UpdateExisting:
+			policy = importExistingUpdate
+		}
+
+		filename := args[0]
+		format := importOpts.Format
+		if format == "" {
+			format = documentFormat(filename)
+			if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+				format = "csv"
+			}
+		}
+
+		var (
+			doc    Document
+			errs   importErrors
+			lines  map[string]int
+			isCSV  = format == "csv"
+			locate = func(object string) string {
+				if line, ok := lines[strings.TrimPrefix(object, "mailbox ")]; ok {
+					return fmt.Sprintf("line %d: ", line)
+				}
+				return ""
+			}
+		)
+
+		if isCSV {
+			rd := io.Reader(os.Stdin)
+			if filename != "-" {
+				f, err := os.Open(filename)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				rd = f
+			}
+
+			var err error
+			doc, lines, errs, err = readMailboxCSV(rd, importOpts.Scheme)
+			if err != nil {
+				return err
+			}
+		} else {
+			var err error
+			doc, err = readDocument(filename, format)
+			if err != nil {
+				return err
+			}
+
+			err = doc.Validate()
+			if err != nil {
+				return fmt.Errorf("invalid file %v:\n%v", filename, err)
+			}
+		}
+
+		return opts.db.WithTx(func(tx *DB) error {
+			doc, existing, err := filterExisting(tx, doc, policy, isCSV, locate)
+			if err != nil {
+				return err
+			}
+
+			errs = append(errs, existing...)
+			if len(errs) > 0 {
+				return fmt.Errorf("import of %v failed:\n%v", filename, errs.err())
+			}
+
+			plan, err := planApply(tx, doc, false)
+			if err != nil {
+				return err
+			}
+
+			err = plan.Apply(tx)
+			if err != nil {
+				return err
+			}
+
+			changes := plan.Changes()
+			for _, c := range changes {
+				msg("%v", c)
+			}
+
+			msg("%d changes imported", len(changes))
+			return nil
+		})
+	},
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testImportCSV = `address,password,quota,send_only
+# comment
+
+alice@example.com,` + testPasswordHash + `,1000
+bob@example.com,"` + testPasswordHash + `",,true
+"broken
+address",secret
+carol@example.com,` + testPasswordHash + `,lots
+alice@example.com,` + testPasswordHash + `
+dave@example.com
+erin@example.com,correct horse battery staple,0,false
+`
+
+func TestReadMailboxCSV(t *testing.T) {
+	doc, lines, errs, err := readMailboxCSV(strings.NewReader(testImportCSV), "SHA512-CRYPT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantErrs := importErrors{
+		`line 6: invalid email address "broken\naddress"`,
+		`line 8: invalid quota "lots"`,
+		"line 9: mailbox alice@example.com already listed in line 4",
+		"line 10: want 2 to 4 columns (address, password, quota, send_only), got 1",
+	}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("wrong errors, want:\n  %v\ngot:\n  %v",
+			strings.Join(wantErrs, "\n  "), strings.Join(errs, "\n  "))
+	}
+
+	wantLines := map[string]int{
+		"alice@example.com": 4,
+		"bob@example.com":   5,
+		"erin@example.com":  11,
+	}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Errorf("want lines %v, got %v", wantLines, lines)
+	}
+
+	if len(doc.Domains) != 1 || len(doc.Domains[0].Mailboxes) != 3 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+
+	alice, bob, erin := doc.Domains[0].Mailboxes[0], doc.Domains[0].Mailboxes[1], doc.Domains[0].Mailboxes[2]
+	if alice.Quota == nil || *alice.Quota != 1000 || alice.SendOnly != nil || alice.PasswordHash != testPasswordHash {
+		t.Errorf("wrong mailbox for alice: %+v", alice)
+	}
+
+	if bob.Quota != nil || bob.SendOnly == nil || !*bob.SendOnly {
+		t.Errorf("wrong mailbox for bob: %+v", bob)
+	}
+
+	if erin.Quota == nil || *erin.Quota != 0 || erin.SendOnly == nil || *erin.SendOnly ||
+		!strings.HasPrefix(erin.PasswordHash, "{SHA512-CRYPT}") {
+		t.Errorf("wrong mailbox for erin: %+v", erin)
+	}
+}
+
+func TestParseMailboxRecord(t *testing.T) {
+	var tests = []struct {
+		record []string
+		err    string
+	}{
+		{[]string{"alice@example.com", testPasswordHash}, ""},
+		{[]string{"alice@example.com", testPasswordHash, "10", "yes"}, `invalid value for send_only "yes"`},
+		{[]string{"alice@example.com", testPasswordHash, "-1"}, `invalid quota "-1"`},
+		{[]string{"alice", testPasswordHash}, `invalid email address "alice"`},
+		{[]string{"alice@example.com", "{SHA512-CRYPT}foo"}, "hash is invalid"},
+	}
+
+	for _, test := range tests {
+		_, err := parseMailboxRecord(test.record, "SHA512-CRYPT")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%v: unexpected error %v", test.record, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%v: want error %q, got %v", test.record, test.err, err)
+		}
+	}
+}
+
+func TestFilterExisting(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAccount(Account{Username: "alice", Domain: "example.com", Password: testPasswordHash, Quota: 500, Sendonly: true, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const input = "alice@example.com," + testPasswordHash + "\nbob@example.com," + testPasswordHash + ",2000\n"
+
+	read := func(t testing.TB) (Document, func(string) string) {
+		doc, lines, errs, err := readMailboxCSV(strings.NewReader(input), "SHA512-CRYPT")
+		if err != nil || len(errs) > 0 {
+			t.Fatal(err, errs)
+		}
+
+		return doc, func(object string) string {
+			return fmt.Sprintf("line %d: ", lines[strings.TrimPrefix(object, "mailbox ")])
+		}
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		doc, locate := read(t)
+		_, errs, err := filterExisting(db, doc, importExistingFail, true, locate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := importErrors{"line 1: mailbox alice@example.com already exists"}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("want errors %v, got %v", want, errs)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		doc, locate := read(t)
+		doc, errs, err := filterExisting(db, doc, importExistingSkip, true, locate)
+		if err != nil || len(errs) > 0 {
+			t.Fatal(err, errs)
+		}
+
+		if len(doc.Domains[0].Mailboxes) != 1 || doc.Domains[0].Mailboxes[0].Address != "bob@example.com" {
+			t.Errorf("alice was not skipped: %+v", doc.Domains[0].Mailboxes)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		doc, locate := read(t)
+		doc, errs, err := filterExisting(db, doc, importExistingUpdate, true, locate)
+		if err != nil || len(errs) > 0 {
+			t.Fatal(err, errs)
+		}
+
+		if len(doc.Domains[0].Mailboxes) != 2 {
+			t.Fatalf("want both mailboxes, got %+v", doc.Domains[0].Mailboxes)
+		}
+
+		alice, err := db.FindAccount("alice", "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alice.Enabled = false
+		err = db.UpdateAccount(alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		applyDocument(t, db, doc, false)
+
+		accounts, err := db.FindAllAccounts("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(accounts) != 2 {
+			t.Fatalf("want 2 mailboxes, got %+v", accounts)
+		}
+
+		// the columns missing in the CSV file must not change alice
+		alice = accounts[0]
+		if alice.Quota != 500 || !alice.Sendonly || alice.IsEnabled() {
+			t.Errorf("alice was changed: %+v", alice)
+		}
+
+		bob := accounts[1]
+		if bob.Quota != 2000 || bob.Sendonly || !bob.IsEnabled() {
+			t.Errorf("wrong mailbox for bob: %+v", bob)
+		}
+	})
+
+	t.Run("missing-domain", func(t *testing.T) {
+		doc, _, errs, err := readMailboxCSV(strings.NewReader("carol@example.net,"+testPasswordHash+"\n"), "SHA512-CRYPT")
+		if err != nil || len(errs) > 0 {
+			t.Fatal(err, errs)
+		}
+
+		_, errs, err = filterExisting(db, doc, importExistingFail, true, func(string) string { return "" })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := importErrors{"domain example.net does not exist"}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("want errors %v, got %v", want, errs)
+		}
+	})
+}
+
+func TestFilterExistingDomain(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := false
+	doc := Document{Domains: []DocumentDomain{{
+		Name:    "example.com",
+		Enabled: &disabled,
+		Limits:  &DocumentLimits{MaxMailboxes: 5},
+	}}}
+
+	for policy, keep := range map[int]bool{
+		importExistingFail:   false,
+		importExistingSkip:   false,
+		importExistingUpdate: true,
+	} {
+		res, errs, err := filterExisting(db, doc, policy, true, func(string) string { return "" })
+		if err != nil || len(errs) > 0 {
+			t.Fatal(err, errs)
+		}
+
+		d := res.Domains[0]
+		if kept := d.Enabled != nil && d.Limits != nil; kept != keep {
+			t.Errorf("policy %v: want settings of the existing domain kept %v, got %+v", policy, keep, d)
+		}
+	}
+}
+
+func TestImportRollback(t *testing.T) {
+	db := useTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SetDomainLimits("example.com", DomainLimits{MaxAccounts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "mailboxes.csv")
+	err = ioutil.WriteFile(filename, []byte("alice@example.com,"+testPasswordHash+"\nbob@example.com,"+testPasswordHash+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := importOpts
+	importOpts.Format, importOpts.SkipExisting, importOpts.UpdateExisting = "", false, false
+	defer func() {
+		importOpts = prev
+	}()
+
+	err = cmdImport.RunE(cmdImport, []string{filename})
+	if err == nil || !strings.Contains(err.Error(), "bob@example.com") {
+		t.Fatalf("import of the second mailbox did not fail as expected, err %v", err)
+	}
+
+	accounts, err := db.FindAllAccounts("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 0 {
+		t.Errorf("first mailbox was not rolled back: %+v", accounts)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var logOpts = struct {
+	Since  string
+	Object string
+}{}
+
+func init() {
+	cmdLog.Flags().StringVar(&logOpts.Since, "since", "", "only show changes after `time` (e.g. 2006-01-02, 2006-01-02T15:04:05Z07:00, 24h or 7d)")
+	cmdLog.Flags().StringVar(&logOpts.Object, "object", "", "only show changes for objects which contain `name`")
+	root.AddCommand(cmdLog)
+}
+
+// parseSince parses the argument for --since: a timestamp, a date (in the
+// local time zone), or a duration before now like "24h" or "7d".
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, use a date, a timestamp or a duration like 24h or 7d", s)
+}
+
+var cmdLog = &cobra.Command{
+	Use:   "log [flags]",
+	Short: "Show the history of changes",
+	Long: `Show the history of changes.
+
+All changes made with vmail are recorded in the audit log, with the user who
+ran vmail, the command line and the values before and after the change.
+Password hashes are never recorded.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			return errors.New("the 'log' command does not take any parameters")
+		}
+
+		since, err := parseSince(logOpts.Since, time.Now())
+		if err != nil {
+			return err
+		}
+
+		entries, err := opts.db.AuditLog(since, logOpts.Object)
+		if err != nil {
+			return err
+		}
+
+		if machineOutput() {
+			if entries == nil {
+				entries = []auditEntry{}
+			}
+			return writeOutput(os.Stdout, entries)
+		}
+
+		if len(entries) == 0 {
+			msg("no changes found")
+			return nil
+		}
+
+		t := newColoredTable()
+		t.AddColumn(" Time ", " {{ .Time }} ")
+		t.AddColumn(" User ", " {{ .User }} ")
+		t.AddColumn(" Action ", " {{ .Action }} ")
+		t.AddColumn(" Object ", " {{ .Object }} ")
+		t.AddColumn(" Changes ", " {{ .Changes }} ")
+
+		for _, e := range entries {
+			t.AddRow(e)
+		}
+
+		return t.Write(os.Stdout)
+	},
+}
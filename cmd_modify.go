@@ -43,15 +43,20 @@ var cmdModify = &cobra.Command{
 			return errors.New("no aliases found")
 		}
 
+		d, err := opts.db.domainState(domain)
+		if err != nil {
+			return err
+		}
+
 		for _, alias := range aliases {
 			if modifyOpts.Blacklist {
 				alias.Blacklisted = true
 			}
 
 			if modifyOpts.Enable {
-				alias.Enabled = true
+				alias.Enabled, alias.Suspended = suspendState(true, d.receives())
 			} else if modifyOpts.Disable {
-				alias.Enabled = false
+				alias.Enabled, alias.Suspended = false, false
 			}
 
 			err := opts.db.UpdateAlias(alias)
@@ -65,3 +70,297 @@ var cmdModify = &cobra.Command{
 		return nil
 	},
 }
+
+var modifyMailboxOpts = struct {
+	Quota    uint64
+	Enable   bool
+	Disable  bool
+	SendOnly bool
+	Receive  bool
+}{}
+
+func init() {
+	cmdModifyMailbox.Flags().Uint64Var(&modifyMailboxOpts.Quota, "quota", 0, "grant this mailbox `bytes` (0 for unlimited)")
+	cmdModifyMailbox.Flags().BoolVar(&modifyMailboxOpts.Enable, "enable", false, "Enable mailbox")
+	cmdModifyMailbox.Flags().BoolVar(&modifyMailboxOpts.Disable, "disable", false, "Disable mailbox")
+	cmdModifyMailbox.Flags().BoolVar(&modifyMailboxOpts.SendOnly, "send-only", false, "do not receive mail for this mailbox")
+	cmdModifyMailbox.Flags().BoolVar(&modifyMailboxOpts.Receive, "receive", false, "receive mail for this mailbox (undo --send-only)")
+
+	cmdModify.AddCommand(cmdModifyMailbox)
+}
+
+var cmdModifyMailbox = &cobra.Command{
+	Use:   "mailbox [flags] user@domain",
+	Short: "Modify quota and flags of a mailbox",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("pass mailbox to modify as parameter (foo@example.com)")
+		}
+
+		if modifyMailboxOpts.Enable && modifyMailboxOpts.Disable {
+			return errors.New("--enable and --disable are mutually exclusive")
+		}
+
+		if modifyMailboxOpts.SendOnly && modifyMailboxOpts.Receive {
+			return errors.New("--send-only and --receive are mutually exclusive")
+		}
+
+		mailbox := args[0]
+		user, domain, err := splitMailAddress(mailbox)
+		if err != nil {
+			return err
+		}
+
+		return opts.db.WithTx(func(tx *DB) error {
+			before, err := tx.FindAccount(user, domain)
+			if err != nil {
+				return fmt.Errorf("mailbox %v not found: %v", mailbox, err)
+			}
+
+			d, err := tx.FindDomain(domain)
+			if err != nil {
+				return err
+			}
+
+			after := before
+			if cmd.Flags().Changed("quota") {
+				after.Quota = int(modifyMailboxOpts.Quota)
+			}
+
+			if modifyMailboxOpts.Enable {
+				after.Enabled, after.Suspended = suspendState(true, d.Enabled)
+			} else if modifyMailboxOpts.Disable {
+				after.Enabled, after.Suspended = false, false
+			}
+
+			if modifyMailboxOpts.SendOnly {
+				after.Sendonly, after.DomainSendonly = true, false
+			} else if modifyMailboxOpts.Receive {
+				after.Sendonly, after.DomainSendonly = sendonlyState(false, d.Sendonly)
+			}
+
+			var fields fieldChanges
+			fields.add("quota", before.Quota, after.Quota)
+			fields.add("enabled", before.IsEnabled(), after.IsEnabled())
+			fields.add("send-only", before.IsSendonly(), after.IsSendonly())
+
+			if len(fields) == 0 {
+				msg("mailbox %v not changed (quota %v, enabled %v, send-only %v)",
+					mailbox, before.Quota, before.IsEnabled(), before.IsSendonly())
+				return nil
+			}
+
+			err = tx.UpdateAccount(after)
+			if err != nil {
+				return fmt.Errorf("updating mailbox %v failed: %v", mailbox, err)
+			}
+
+			msg("mailbox %v updated: %v", mailbox, fields)
+			if after.Suspended {
+				msg("domain %v is disabled, the mailbox is suspended until it is enabled", domain)
+			}
+			if after.DomainSendonly {
+				msg("domain %v is send-only, the mailbox does not receive mail until the domain does", domain)
+			}
+			return nil
+		})
+	},
+}
+
+var modifyDomainOpts = struct {
+	Enable       bool
+	Disable      bool
+	SendOnly     bool
+	Receive      bool
+	MaxMailboxes uint64
+	MaxAliases   uint64
+	MaxQuota     uint64
+	DefaultQuota uint64
+}{}
+
+func init() {
+	cmdModifyDomain.Flags().BoolVar(&modifyDomainOpts.Enable, "enable", false, "enable the domain and restore all suspended mailboxes and aliases")
+	cmdModifyDomain.Flags().BoolVar(&modifyDomainOpts.Disable, "disable", false, "disable the domain and suspend all mailboxes and aliases")
+	cmdModifyDomain.Flags().BoolVar(&modifyDomainOpts.SendOnly, "send-only", false, "do not receive mail for the domain, make all mailboxes send-only and suspend all aliases")
+	cmdModifyDomain.Flags().BoolVar(&modifyDomainOpts.Receive, "receive", false, "receive mail for the domain again (undo --send-only)")
+	cmdModifyDomain.Flags().Uint64Var(&modifyDomainOpts.MaxMailboxes, "max-mailboxes", 0, "allow at most `n` mailboxes (0 for unlimited)")
+	cmdModifyDomain.Flags().Uint64Var(&modifyDomainOpts.MaxAliases, "max-aliases", 0, "allow at most `n` alias addresses (0 for unlimited)")
+	cmdModifyDomain.Flags().Uint64Var(&modifyDomainOpts.MaxQuota, "max-quota", 0, "limit the sum of all mailbox quotas to `bytes` (0 for unlimited)")
+	cmdModifyDomain.Flags().Uint64Var(&modifyDomainOpts.DefaultQuota, "default-quota", 0, "grant new mailboxes `bytes` unless --quota is passed (0 for none)")
+
+	cmdModify.AddCommand(cmdModifyDomain)
+}
+
+var cmdModifyDomain = &cobra.Command{
+	Use:   "domain [flags] name",
+	Short: "Enable or disable a domain, make it send-only and change its limits",
+	Long: `Enable or disable a domain, make it send-only and change its limits.
+
+Disabling a domain suspends all mailboxes and aliases in it which are
+enabled: they are disabled, but marked as suspended. When the domain is
+enabled again, only the suspended mailboxes and aliases are enabled, so
+mailboxes and aliases which were disabled before stay disabled.
+
+A send-only domain does not receive mail. All mailboxes in it are made
+send-only and all aliases are suspended, in the same way as for a disabled
+domain. With --receive, only the mailboxes which were not send-only before
+receive mail again.
+
+The limits restrict the number of mailboxes and aliases and the sum of all
+mailbox quotas, they are checked when mailboxes and aliases are created and
+when quotas are changed. When the total quota is limited, every mailbox
+needs a quota.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("pass the domain to modify as parameter")
+		}
+
+		if modifyDomainOpts.Enable && modifyDomainOpts.Disable {
+			return errors.New("pass either --enable or --disable")
+		}
+
+		if modifyDomainOpts.SendOnly && modifyDomainOpts.Receive {
+			return errors.New("pass either --send-only or --receive")
+		}
+
+		limitFlags := []string{"max-mailboxes", "max-aliases", "max-quota", "default-quota"}
+		changeLimits := false
+		for _, name := range limitFlags {
+			if cmd.Flags().Changed(name) {
+				changeLimits = true
+			}
+		}
+
+		changeEnabled := modifyDomainOpts.Enable || modifyDomainOpts.Disable
+		changeSendonly := modifyDomainOpts.SendOnly || modifyDomainOpts.Receive
+		if !changeEnabled && !changeSendonly && !changeLimits {
+			return errors.New("pass --enable, --disable, --send-only, --receive or the limits to change")
+		}
+
+		name := args[0]
+		return opts.db.WithTx(func(tx *DB) error {
+			d, err := tx.FindDomain(name)
+			if err != nil {
+				return err
+			}
+
+			if changeLimits {
+				err = modifyDomainLimits(cmd, tx, d)
+				if err != nil {
+					return err
+				}
+			}
+
+			if changeSendonly {
+				err = modifyDomainSendonly(tx, d, modifyDomainOpts.SendOnly)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !changeEnabled {
+				return nil
+			}
+
+			if d.Enabled == modifyDomainOpts.Enable {
+				msg("domain %v not changed (enabled %v)", name, d.Enabled)
+				return nil
+			}
+
+			c, err := tx.SetDomainEnabled(name, modifyDomainOpts.Enable)
+			if err != nil {
+				return fmt.Errorf("updating domain %v failed: %v", name, err)
+			}
+
+			if modifyDomainOpts.Enable {
+				msg("domain %v enabled, %d mailboxes and %d aliases restored", name, c.Mailboxes, c.Aliases)
+			} else {
+				msg("domain %v disabled, %d mailboxes and %d aliases suspended", name, c.Mailboxes, c.Aliases)
+			}
+
+			return nil
+		})
+	},
+}
+
+// modifyDomainSendonly makes the domain d send-only or lets it receive mail
+// again.
+func modifyDomainSendonly(tx *DB, d Domain, sendonly bool) error {
+	if d.Sendonly == sendonly {
+		msg("domain %v not changed (send-only %v)", d.Domain, d.Sendonly)
+		return nil
+	}
+
+	c, err := tx.SetDomainSendonly(d.Domain, sendonly)
+	if err != nil {
+		return fmt.Errorf("updating domain %v failed: %v", d.Domain, err)
+	}
+
+	if sendonly {
+		msg("domain %v is send-only, %d mailboxes made send-only and %d aliases suspended", d.Domain, c.Mailboxes, c.Aliases)
+	} else {
+		msg("domain %v receives mail, %d mailboxes and %d aliases restored", d.Domain, c.Mailboxes, c.Aliases)
+	}
+
+	return nil
+}
+
+// modifyDomainLimits sets the limits of d passed on the command line and
+// warns when the domain already exceeds them.
+func modifyDomainLimits(cmd *cobra.Command, tx *DB, d Domain) error {
+	limits := d.DomainLimits
+	if cmd.Flags().Changed("max-mailboxes") {
+		limits.MaxAccounts = int(modifyDomainOpts.MaxMailboxes)
+	}
+	if cmd.Flags().Changed("max-aliases") {
+		limits.MaxAliases = int(modifyDomainOpts.MaxAliases)
+	}
+	if cmd.Flags().Changed("max-quota") {
+		limits.MaxQuota = int(modifyDomainOpts.MaxQuota)
+	}
+	if cmd.Flags().Changed("default-quota") {
+		limits.DefaultQuota = int(modifyDomainOpts.DefaultQuota)
+	}
+
+	if limits.MaxQuota > 0 && limits.DefaultQuota > limits.MaxQuota {
+		return fmt.Errorf("default quota %d is larger than the total quota %d", limits.DefaultQuota, limits.MaxQuota)
+	}
+
+	var fields fieldChanges
+	fields.add("max mailboxes", d.MaxAccounts, limits.MaxAccounts)
+	fields.add("max aliases", d.MaxAliases, limits.MaxAliases)
+	fields.add("max quota", d.MaxQuota, limits.MaxQuota)
+	fields.add("default quota", d.DefaultQuota, limits.DefaultQuota)
+
+	if len(fields) == 0 {
+		msg("limits for domain %v not changed", d.Domain)
+		return nil
+	}
+
+	err := tx.SetDomainLimits(d.Domain, limits)
+	if err != nil {
+		return fmt.Errorf("updating domain %v failed: %v", d.Domain, err)
+	}
+
+	msg("domain %v updated: %v", d.Domain, fields)
+
+	usage, err := tx.DomainUsage(d.Domain)
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxAccounts > 0 && usage.Accounts > limits.MaxAccounts {
+		warn("domain %v has %d mailboxes, more than the limit", d.Domain, usage.Accounts)
+	}
+	if limits.MaxAliases > 0 && usage.Aliases > limits.MaxAliases {
+		warn("domain %v has %d aliases, more than the limit", d.Domain, usage.Aliases)
+	}
+	if limits.MaxQuota > 0 && usage.Quota > limits.MaxQuota {
+		warn("the total quota of domain %v is %d bytes, more than the limit", d.Domain, usage.Quota)
+	}
+	if limits.MaxQuota > 0 && usage.Unlimited > 0 {
+		warn("domain %v has %d mailboxes without a quota", d.Domain, usage.Unlimited)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// setFlags sets the flags of cmd and resets all flags to their defaults when
+// the test is done.
+func setFlags(t testing.TB, cmd *cobra.Command, flags map[string]string) {
+	t.Cleanup(func() {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			_ = f.Value.Set(f.DefValue)
+			f.Changed = false
+		})
+	})
+
+	for name, value := range flags {
+		err := cmd.Flags().Set(name, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestModifyMailbox(t *testing.T) {
+	db := useTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAccount(Account{Username: "admin", Domain: "example.com", Password: testPasswordHash, Quota: 1000, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlags(t, cmdModifyMailbox, map[string]string{
+		"quota":     "2000",
+		"disable":   "true",
+		"send-only": "true",
+	})
+
+	err = cmdModifyMailbox.RunE(cmdModifyMailbox, []string{"admin@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := db.FindAccount("admin", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Quota != 2000 || a.IsEnabled() || !a.Sendonly || a.Password != testPasswordHash {
+		t.Errorf("mailbox not updated as expected: %+v", a)
+	}
+
+	entries, err := db.AuditLog(time.Time{}, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[1].Action != "update" {
+		t.Fatalf("want create and update in the audit log, got %+v", entries)
+	}
+
+	want := "enabled true -> false, quota 1000 -> 2000, send_only false -> true"
+	if changes := entries[1].Changes(); changes != want {
+		t.Errorf("wrong changes recorded, want %q, got %q", want, changes)
+	}
+
+	// modifying the mailbox again with the same flags does not change anything
+	err = cmdModifyMailbox.RunE(cmdModifyMailbox, []string{"admin@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = db.AuditLog(time.Time{}, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("unchanged mailbox was recorded: %+v", entries)
+	}
+}
+
+func TestModifyMailboxNotFound(t *testing.T) {
+	db := useTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlags(t, cmdModifyMailbox, map[string]string{"quota": "2000"})
+
+	err = cmdModifyMailbox.RunE(cmdModifyMailbox, []string{"nobody@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "mailbox nobody@example.com not found") {
+		t.Fatalf("want error for missing mailbox, got %v", err)
+	}
+
+	entries, err := db.AuditLog(time.Time{}, "nobody@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("missing mailbox was recorded: %+v", entries)
+	}
+}
@@ -1,22 +1,17 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
 )
 
-var passwordOptions = struct {
-	PasswordHash    string
-	Password        string
-	RawPasswordHash bool
-}{}
+var passwordOptions passwordFlags
 
 func init() {
-	cmdPassword.Flags().StringVar(&passwordOptions.Password, "password", "", "use `pwd` as the password")
-	cmdPassword.Flags().StringVar(&passwordOptions.PasswordHash, "password-hash", "", "use `hash` as the password (already hashed)")
-	cmdPassword.Flags().BoolVar(&passwordOptions.RawPasswordHash, "raw-password-hash", false, "do not check password hash")
+	passwordOptions.register(cmdPassword.Flags())
 	root.AddCommand(cmdPassword)
 }
 
@@ -34,31 +29,106 @@ var cmdPassword = &cobra.Command{
 			return err
 		}
 
-		var pwhash = createMailboxOpts.PasswordHash
-		if pwhash == "" {
-			var pw = createMailboxOpts.Password
-			if pw == "" && createMailboxOpts.PasswordHash == "" {
-				pw, err = readPassword()
-				if err != nil {
-					return err
-				}
-			}
+		pwhash, generated, err := passwordOptions.passwordHash(user, domain)
+		if err != nil {
+			return err
+		}
+
+		err = opts.db.UpdateAccountPassword(user, domain, pwhash)
+		if err != nil {
+			return fmt.Errorf("updating password for %v failed: %v", mailbox, err)
+		}
+		msg("password for %v updated", mailbox)
+		return passwordOptions.reveal(mailbox, generated)
+	},
+}
 
-			pwhash = hashPassword(pw)
+// exitPasswordMismatch is the exit status of 'vmail password check' when the
+// password does not match.
+const exitPasswordMismatch = 2
+
+var passwordCheckOptions = struct {
+	Password string
+	Input    passwordInput
+}{}
+
+func init() {
+	cmdPasswordCheck.Flags().StringVar(&passwordCheckOptions.Password, "password", "", "check `pwd` instead of asking for the password")
+	passwordCheckOptions.Input.register(cmdPasswordCheck.Flags())
+	cmdPassword.AddCommand(cmdPasswordCheck)
+}
+
+var cmdPasswordCheck = &cobra.Command{
+	Use:   "check [flags] user@domain",
+	Short: "Check a password against the hash stored for a mailbox",
+	Long: `Check a password against the hash stored for a mailbox.
+
+The password is verified locally, the supported schemes are SHA512-CRYPT,
+SHA256-CRYPT, BLF-CRYPT, ARGON2ID and PLAIN. The exit status is 0 if the
+password matches, 2 if it does not match and 1 for all other errors.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 1 {
+			return errors.New("pass mailbox to check as parameter (foo@example.com)")
 		}
 
-		if !passwordOptions.RawPasswordHash {
-			err = checkHash(pwhash)
+		mailbox := args[0]
+		user, domain, err := splitMailAddress(mailbox)
+		if err != nil {
+			return err
+		}
+
+		account, err := opts.db.FindAccount(user, domain)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("mailbox %v not found", mailbox)
+		}
+		if err != nil {
+			return err
+		}
+
+		scheme, hash, v, err := findVerifier(account.Password)
+		if err != nil {
+			return fmt.Errorf("mailbox %v: %v", mailbox, err)
+		}
+
+		params, err := v.Params(hash)
+		if err != nil {
+			return fmt.Errorf("mailbox %v: hash is invalid (%v: %v)", mailbox, scheme, err)
+		}
+
+		var pw = passwordCheckOptions.Password
+		switch {
+		case pw != "" && passwordCheckOptions.Input.set():
+			return errors.New("--password, --password-stdin and --password-fd are mutually exclusive")
+		case passwordCheckOptions.Input.set():
+			pw, err = passwordCheckOptions.Input.read()
+			if err != nil {
+				return err
+			}
+		case pw == "":
+			pw, err = promptPassword("enter password")
 			if err != nil {
 				return err
 			}
 		}
 
-		err = opts.db.UpdateAccountPassword(user, domain, pwhash)
+		ok, err := v.Verify(pw, hash)
 		if err != nil {
-			return fmt.Errorf("updating password for %v failed: %v", mailbox, err)
+			return fmt.Errorf("mailbox %v: %v", mailbox, err)
+		}
+
+		msg("scheme %v, %v", scheme, params)
+
+		if !ok {
+			return exitError{
+				code: exitPasswordMismatch,
+				err:  fmt.Errorf("password for %v does not match", mailbox),
+			}
+		}
+
+		msg("password for %v matches", mailbox)
+		if account.PasswordReset {
+			msg("the mailbox is marked for a password reset")
 		}
-		msg("password for %v updated", mailbox)
 		return nil
 	},
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRename = &cobra.Command{
+	Use:   "rename",
+	Short: "Rename mailboxes and domains",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("the 'rename' command needs to know what to rename: mailbox or domain?")
+	},
+}
+
+var renameMailboxOpts = struct {
+	Forward bool
+}{}
+
+func init() {
+	cmdRenameMailbox.Flags().BoolVar(&renameMailboxOpts.Forward, "forward", false, "create an alias from the old to the new address")
+}
+
+var cmdRenameMailbox = &cobra.Command{
+	Use:   "mailbox [flags] OLD NEW",
+	Short: "Change the address of a mailbox",
+	Long: `Change the address of a mailbox.
+
+The password, quota and flags are kept, and all aliases pointing to the old
+address are changed to point to the new address. The mails stored for the
+mailbox are not moved, depending on the mail server configuration the
+directory needs to be renamed manually.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("pass old and new address as parameters (foo@example.com bar@example.com)")
+		}
+
+		oldUser, oldDomain, err := splitMailAddress(args[0])
+		if err != nil {
+			return err
+		}
+
+		newUser, newDomain, err := splitMailAddress(args[1])
+		if err != nil {
+			return err
+		}
+
+		aliases, err := opts.db.RenameMailbox(oldUser, oldDomain, newUser, newDomain, renameMailboxOpts.Forward)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range aliases {
+			msg("alias %v now points to %v", a.Source(), args[1])
+		}
+
+		if renameMailboxOpts.Forward {
+			msg("alias %v -> %v created", args[0], args[1])
+		}
+
+		msg("mailbox %v renamed to %v", args[0], args[1])
+		return nil
+	},
+}
+
+var renameDomainOpts = struct {
+	Redirect bool
+	Yes      bool
+}{}
+
+func init() {
+	cmdRenameDomain.Flags().BoolVar(&renameDomainOpts.Redirect, "redirect", false, "keep the old domain with aliases for all addresses pointing to the new domain")
+	cmdRenameDomain.Flags().BoolVarP(&renameDomainOpts.Yes, "yes", "y", false, "do not ask for confirmation")
+}
+
+var cmdRenameDomain = &cobra.Command{
+	Use:   "domain [flags] OLD NEW",
+	Short: "Rename a domain with all mailboxes and aliases",
+	Long: `Rename a domain with all mailboxes and aliases.
+
+All mailboxes and aliases are moved to the new domain, aliases in other
+domains pointing to the old domain are changed as well. With --redirect, the
+old domain is kept for a transition period, with an alias for each mailbox
+and alias pointing to the same address in the new domain. The mails stored
+for the mailboxes are not moved.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.New("pass old and new domain name as parameters")
+		}
+
+		oldName, newName := args[0], args[1]
+		r, err := opts.db.PreviewRenameDomain(oldName, newName, renameDomainOpts.Redirect)
+		if err != nil {
+			return err
+		}
+
+		msg("domain %v -> %v", oldName, newName)
+		for _, a := range r.Accounts {
+			msg("  mailbox %v@%v -> %v@%v", a.Username, a.Domain, a.Username, newName)
+		}
+
+		for _, a := range r.Aliases {
+			renamed := a
+			if renamed.SourceDomain == oldName {
+				renamed.SourceDomain = newName
+			}
+			if renamed.DestinationDomain == oldName {
+				renamed.DestinationDomain = newName
+			}
+			msg("  alias %v -> %v changes to %v -> %v",
+				a.Source(), a.Destination(), renamed.Source(), renamed.Destination())
+		}
+
+		for _, a := range r.Redirects {
+			msg("  new alias %v -> %v", a.Source(), a.Destination())
+		}
+
+		if !renameDomainOpts.Yes && !opts.DryRun {
+			ok, err := confirm("rename domain %v to %v?", oldName, newName)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				msg("aborted")
+				return nil
+			}
+		}
+
+		err = opts.db.RenameDomain(oldName, newName, renameDomainOpts.Redirect)
+		if err != nil {
+			return fmt.Errorf("renaming domain %v failed: %v", oldName, err)
+		}
+
+		msg("domain %v renamed to %v", oldName, newName)
+		return nil
+	},
+}
+
+func init() {
+	cmdRename.AddCommand(cmdRenameDomain)
+	cmdRename.AddCommand(cmdRenameMailbox)
+	root.AddCommand(cmdRename)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdSchema = &cobra.Command{
+	Use:   "schema",
+	Short: "Create and migrate the database schema",
+	Long: `Create and migrate the database schema.
+
+vmail records the version of the schema in the table schema_version and
+refuses to run other commands until the schema is up to date. For a database
+created with the howto or an earlier version of vmail, run 'vmail schema
+init' once to start versioning it, followed by 'vmail schema migrate'.`,
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) (err error) {
+		// schema changes cannot be rolled back on MySQL
+		if opts.DryRun {
+			return errors.New("--dry-run is not supported for schema changes")
+		}
+
+		// do not check the schema version, these commands manage it
+		return setup(cmd)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("the 'schema' command needs to know what to do: init, migrate or status?")
+	},
+}
+
+var cmdSchemaInit = &cobra.Command{
+	Use:   "init",
+	Short: "Create the tables in an empty database or start versioning an existing one",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		baseline, err := opts.db.InitSchema()
+		if err != nil {
+			return fmt.Errorf("initializing schema failed: %v", err)
+		}
+
+		version, err := opts.db.SchemaVersion()
+		if err != nil {
+			return err
+		}
+
+		if baseline {
+			msg("existing tables found, schema marked as version %d", version)
+			msg("run 'vmail schema migrate' to apply the remaining migrations")
+			return nil
+		}
+
+		msg("schema created (version %d)", version)
+		return nil
+	},
+}
+
+var schemaMigrateOpts = struct {
+	Target int
+}{}
+
+func init() {
+	cmdSchemaMigrate.Flags().IntVar(&schemaMigrateOpts.Target, "to", -1, "migrate to `version` (default: latest), may be lower than the current version")
+}
+
+var cmdSchemaMigrate = &cobra.Command{
+	Use:   "migrate [flags]",
+	Short: "Upgrade (or downgrade) the database schema",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target := schemaMigrateOpts.Target
+		if target < 0 {
+			latest, err := opts.db.LatestSchemaVersion()
+			if err != nil {
+				return err
+			}
+			target = latest
+		}
+
+		var n int
+		err := opts.db.Migrate(target, func(m migration, up bool) {
+			if up {
+				msg("applying migration %04d_%v", m.Version, m.Name)
+			} else {
+				msg("reverting migration %04d_%v", m.Version, m.Name)
+			}
+			n++
+		})
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			msg("schema is already at version %d", target)
+			return nil
+		}
+
+		msg("schema migrated to version %d", target)
+		return nil
+	},
+}
+
+var cmdSchemaStatus = &cobra.Command{
+	Use:   "status",
+	Short: "Display the version of the database schema",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		version, err := opts.db.SchemaVersion()
+		if err != nil {
+			return err
+		}
+
+		latest, err := opts.db.LatestSchemaVersion()
+		if err != nil {
+			return err
+		}
+
+		msg("schema version %d, latest version %d", version, latest)
+		return nil
+	},
+}
+
+func init() {
+	cmdSchema.AddCommand(cmdSchemaInit)
+	cmdSchema.AddCommand(cmdSchemaMigrate)
+	cmdSchema.AddCommand(cmdSchemaStatus)
+	root.AddCommand(cmdSchema)
+}
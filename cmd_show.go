@@ -24,11 +24,47 @@ func init() {
 
 			name := args[0]
 
-			_, err := opts.db.FindDomain(name)
+			d, err := opts.db.FindDomain(name)
 			if err != nil {
 				return err
 			}
 
+			if machineOutput() {
+				return writeDomainOutput(opts.db, d)
+			}
+
+			if !d.Enabled {
+				msg("domain %v is disabled, all mailboxes and aliases are suspended\n", name)
+			}
+
+			if d.Sendonly {
+				msg("domain %v is send-only, all mailboxes are send-only and all aliases are suspended\n", name)
+			}
+
+			if ad, err := opts.db.FindAliasDomain(name); err == nil {
+				msg("domain %v is an alias domain for %v\n", name, ad.TargetDomain)
+			}
+
+			aliasDomains, err := opts.db.FindAliasDomainsFor(name)
+			if err != nil {
+				return err
+			}
+
+			if len(aliasDomains) > 0 {
+				var names []string
+				for _, ad := range aliasDomains {
+					names = append(names, ad.AliasDomain)
+				}
+				msg("alias domains: %v\n", strings.Join(names, ", "))
+			}
+
+			if d.DomainLimits != (DomainLimits{}) {
+				err = printDomainUsage(opts.db, d)
+				if err != nil {
+					return err
+				}
+			}
+
 			err = printAccounts(opts.db, name)
 			if err != nil {
 				return err
@@ -46,6 +82,115 @@ func init() {
 	})
 }
 
+// domainOutput contains all mailboxes and aliases of a domain for
+// machine-readable output.
+type domainOutput struct {
+	Domain       string          `json:"domain" yaml:"domain"`
+	Enabled      bool            `json:"enabled" yaml:"enabled"`
+	SendOnly     bool            `json:"send_only" yaml:"send_only"`
+	AliasFor     string          `json:"alias_for,omitempty" yaml:"alias_for,omitempty"`
+	AliasDomains []string        `json:"alias_domains,omitempty" yaml:"alias_domains,omitempty"`
+	Limits       limitsRecord    `json:"limits" yaml:"limits"`
+	Usage        usageRecord     `json:"usage" yaml:"usage"`
+	Mailboxes    []accountRecord `json:"mailboxes" yaml:"mailboxes"`
+	Aliases      []aliasRecord   `json:"aliases" yaml:"aliases"`
+}
+
+// writeDomainOutput prints the mailboxes and aliases of a domain in the
+// format selected with --output. For CSV and TSV, the mailboxes and the
+// aliases are printed as two tables separated by an empty line.
+func writeDomainOutput(db *DB, d Domain) error {
+	name := d.Domain
+
+	accounts, err := db.FindAllAccounts(name)
+	if err != nil {
+		return err
+	}
+
+	aliases, err := db.FindAllAliases(name)
+	if err != nil {
+		return err
+	}
+
+	out := domainOutput{
+		Domain:    name,
+		Enabled:   d.Enabled,
+		SendOnly:  d.Sendonly,
+		Mailboxes: make([]accountRecord, 0, len(accounts)),
+		Aliases:   make([]aliasRecord, 0, len(aliases)),
+	}
+
+	if ad, err := db.FindAliasDomain(name); err == nil {
+		out.AliasFor = ad.TargetDomain
+	}
+
+	usage, err := db.DomainUsage(name)
+	if err != nil {
+		return err
+	}
+	out.Limits, out.Usage = newLimitsRecord(d.DomainLimits), newUsageRecord(usage)
+
+	aliasDomains, err := db.FindAliasDomainsFor(name)
+	if err != nil {
+		return err
+	}
+
+	for _, ad := range aliasDomains {
+		out.AliasDomains = append(out.AliasDomains, ad.AliasDomain)
+	}
+
+	for _, a := range accounts {
+		out.Mailboxes = append(out.Mailboxes, newAccountRecord(a))
+	}
+
+	for _, a := range aliases {
+		out.Aliases = append(out.Aliases, newAliasRecord(a))
+	}
+
+	if opts.Output != "csv" && opts.Output != "tsv" {
+		return writeOutput(os.Stdout, out)
+	}
+
+	err = writeOutput(os.Stdout, out.Mailboxes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+
+	return writeOutput(os.Stdout, out.Aliases)
+}
+
+// printDomainUsage prints the number of mailboxes and aliases and the total
+// quota of the domain together with the limits.
+func printDomainUsage(db *DB, d Domain) error {
+	usage, err := db.DomainUsage(d.Domain)
+	if err != nil {
+		return err
+	}
+
+	limit := func(used, max int, unit string) string {
+		if max == 0 {
+			return fmt.Sprintf("%d%v (unlimited)", used, unit)
+		}
+		return fmt.Sprintf("%d of %d%v", used, max, unit)
+	}
+
+	msg("mailboxes:     %v", limit(usage.Accounts, d.MaxAccounts, ""))
+	msg("aliases:       %v", limit(usage.Aliases, d.MaxAliases, ""))
+	quota := limit(usage.Quota, d.MaxQuota, " bytes")
+	if usage.Unlimited > 0 {
+		quota += fmt.Sprintf(", %d mailboxes without quota", usage.Unlimited)
+	}
+	msg("quota:         %v", quota)
+	if d.DefaultQuota > 0 {
+		msg("default quota: %d bytes", d.DefaultQuota)
+	}
+	fmt.Println()
+
+	return nil
+}
+
 func printAccounts(db *DB, name string) error {
 	accounts, err := opts.db.FindAllAccounts(name)
 	if err != nil {
@@ -59,7 +204,8 @@ func printAccounts(db *DB, name string) error {
 	t := newColoredTable()
 	t.AddColumn(" Mailbox ", " {{ .Username }}@{{ .Domain }} ")
 	t.AddColumn(" Quota ", " {{ if gt .Quota 0 }}{{ .Quota }}{{ end }} ")
-	t.AddColumn(" Enabled ", " {{ .Enabled }} ")
+	t.AddColumn(" Enabled ", " {{ if .Suspended }}suspended{{ else }}{{ .Enabled }}{{ end }} ")
+	t.AddColumn(" Send-only ", " {{ if .DomainSendonly }}domain{{ else }}{{ .Sendonly }}{{ end }} ")
 
 	for _, a := range accounts {
 		t.AddRow(a)
@@ -148,7 +294,11 @@ func printAliases(db *DB, domain string) error {
 		for _, a := range aliasList[name] {
 			destinations = append(destinations, a.DestinationUsername+"@"+a.DestinationDomain)
 			blacklisted = append(blacklisted, fmt.Sprintf("%v", a.Blacklisted))
-			enabled = append(enabled, fmt.Sprintf("%v", a.Enabled))
+			if a.Suspended {
+				enabled = append(enabled, "suspended")
+			} else {
+				enabled = append(enabled, fmt.Sprintf("%v", a.Enabled))
+			}
 		}
 
 		t.AddRow(rowData{
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdTLSPolicy = &cobra.Command{
+	Use:   "tlspolicy",
+	Short: "Manage TLS policies for outgoing mail",
+	Long: `Manage TLS policies for outgoing mail.
+
+The policies are stored in the table tlspolicies, which Postfix uses for
+smtp_tls_policy_maps. The policy is one of none, may, encrypt, dane,
+dane-only, fingerprint, verify and secure, the parameters are attributes
+like "match=.example.com" or "protocols=>=TLSv1.2".`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("the 'tlspolicy' command needs to know what to do: add, list, delete or modify?")
+	},
+}
+
+// newTLSPolicy returns the TLS policy for the domain after checking the
+// policy and params.
+func newTLSPolicy(domain, policy string, params []string) (TLSPolicy, error) {
+	if domain == "" || strings.ContainsAny(domain, " \t") {
+		return TLSPolicy{}, fmt.Errorf("invalid domain %q", domain)
+	}
+
+	p := strings.Join(params, " ")
+	err := checkTLSPolicy(policy, p)
+	if err != nil {
+		return TLSPolicy{}, err
+	}
+
+	return TLSPolicy{
+		Domain: domain,
+		Policy: policy,
+		Params: sql.NullString{String: p, Valid: p != ""},
+	}, nil
+}
+
+var cmdTLSPolicyAdd = &cobra.Command{
+	Use:   "add [flags] domain policy [param...]",
+	Short: "Add a TLS policy for a domain",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return errors.New("pass the domain, the policy and optional parameters")
+		}
+
+		p, err := newTLSPolicy(args[0], args[1], args[2:])
+		if err != nil {
+			return err
+		}
+
+		err = opts.db.CreateTLSPolicy(p)
+		if err != nil {
+			return fmt.Errorf("creating TLS policy for %v failed: %v", p.Domain, err)
+		}
+
+		msg("TLS policy for %v created", p.Domain)
+		return nil
+	},
+}
+
+var cmdTLSPolicyList = &cobra.Command{
+	Use:   "list [flags] [filter]",
+	Short: "List TLS policies (with optional filter)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return errors.New("pass at most one filter as parameter")
+		}
+
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+
+		policies, err := opts.db.FindAllTLSPolicies(name)
+		if err != nil {
+			return err
+		}
+
+		records := make([]tlsPolicyRecord, 0, len(policies))
+		for _, p := range policies {
+			records = append(records, newTLSPolicyRecord(p))
+		}
+
+		if machineOutput() {
+			return writeOutput(os.Stdout, records)
+		}
+
+		if len(records) == 0 {
+			msg("no TLS policies found")
+			return nil
+		}
+
+		t := newColoredTable()
+		t.AddColumn(" Domain ", " {{ .Domain }} ")
+		t.AddColumn(" Policy ", " {{ .Policy }} ")
+		t.AddColumn(" Parameters ", " {{ .Params }} ")
+
+		for _, r := range records {
+			t.AddRow(r)
+		}
+
+		return t.Write(os.Stdout)
+	},
+}
+
+var cmdTLSPolicyDelete = &cobra.Command{
+	Use:   "delete [flags] domain",
+	Short: "Delete the TLS policy for a domain",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("pass the domain as parameter")
+		}
+
+		err := opts.db.DeleteTLSPolicy(args[0])
+		if err != nil {
+			return fmt.Errorf("deleting TLS policy for %v failed: %v", args[0], err)
+		}
+
+		msg("TLS policy for %v deleted", args[0])
+		return nil
+	},
+}
+
+var tlsPolicyModifyOpts = struct {
+	Policy string
+	Params string
+}{}
+
+var cmdTLSPolicyModify = &cobra.Command{
+	Use:   "modify [flags] domain",
+	Short: "Change the TLS policy for a domain",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("pass the domain as parameter")
+		}
+
+		if !cmd.Flags().Changed("policy") && !cmd.Flags().Changed("params") {
+			return errors.New("pass the new policy with --policy and/or the parameters with --params")
+		}
+
+		return opts.db.WithTx(func(tx *DB) error {
+			cur, err := tx.FindTLSPolicy(args[0])
+			if err != nil {
+				return fmt.Errorf("TLS policy for %v: %v", args[0], err)
+			}
+
+			policy, params := cur.Policy, cur.Params.String
+			if cmd.Flags().Changed("policy") {
+				policy = tlsPolicyModifyOpts.Policy
+			}
+			if cmd.Flags().Changed("params") {
+				params = tlsPolicyModifyOpts.Params
+			}
+
+			p, err := newTLSPolicy(cur.Domain, policy, strings.Fields(params))
+			if err != nil {
+				return err
+			}
+			p.ID = cur.ID
+
+			var fields fieldChanges
+			fields.add("policy", cur.Policy, p.Policy)
+			fields.add("params", cur.Params.String, p.Params.String)
+
+			if len(fields) == 0 {
+				msg("TLS policy for %v unchanged", p.Domain)
+				return nil
+			}
+
+			err = tx.UpdateTLSPolicy(p)
+			if err != nil {
+				return fmt.Errorf("updating TLS policy for %v failed: %v", p.Domain, err)
+			}
+
+			msg("TLS policy for %v updated: %v", p.Domain, fields)
+			return nil
+		})
+	},
+}
+
+func init() {
+	cmdTLSPolicyModify.Flags().StringVar(&tlsPolicyModifyOpts.Policy, "policy", "", "set the policy to `policy`")
+	cmdTLSPolicyModify.Flags().StringVar(&tlsPolicyModifyOpts.Params, "params", "", "set the parameters to `params` (separated by spaces, empty to remove them)")
+
+	cmdTLSPolicy.AddCommand(cmdTLSPolicyAdd)
+	cmdTLSPolicy.AddCommand(cmdTLSPolicyList)
+	cmdTLSPolicy.AddCommand(cmdTLSPolicyDelete)
+	cmdTLSPolicy.AddCommand(cmdTLSPolicyModify)
+	root.AddCommand(cmdTLSPolicy)
+}
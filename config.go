@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	mysql "github.com/Go-SQL-Driver/MySQL"
+	"github.com/spf13/cobra"
+)
+
+// Config is the configuration file with named profiles, e.g. one for each
+// mail server.
+type Config struct {
+	// Default is the name of the profile used when --profile is not given.
+	Default  string             `toml:"default"`
+	Profiles map[string]Profile `toml:"profiles"`
+}
+
+// Profile contains the settings for one mail server. Empty settings are not
+// used.
+type Profile struct {
+	Database             string        `toml:"database"`
+	DatabasePasswordFile string        `toml:"database_password_file"`
+	HashScheme           string        `toml:"hash_scheme"`
+	Quota                *uint64       `toml:"quota"`
+	Output               string        `toml:"output"`
+	AuditLogFile         string        `toml:"audit_log_file"`
+	PasswordPolicy       ProfilePolicy `toml:"password_policy"`
+}
+
+// ProfilePolicy configures the password policy.
+type ProfilePolicy struct {
+	MinLength         *int   `toml:"min_length"`
+	MaxLength         *int   `toml:"max_length"`
+	MinClasses        *int   `toml:"min_classes"`
+	AllowAddress      *bool  `toml:"allow_address"`
+	BreachedPasswords string `toml:"breached_passwords"`
+}
+
+// configFiles returns the configuration files in the order they are read,
+// profiles in later files replace profiles with the same name.
+func configFiles() []string {
+	files := []string{"/etc/vmail.toml"}
+
+	dir, err := os.UserConfigDir()
+	if err == nil {
+		files = append(files, filepath.Join(dir, "vmail", "config.toml"))
+	}
+
+	return files
+}
+
+// loadConfig reads and merges the configuration files, files which do not
+// exist are ignored.
+func loadConfig(files []string) (Config, error) {
+	cfg := Config{Profiles: make(map[string]Profile)}
+
+	for _, filename := range files {
+		var c Config
+		md, err := toml.DecodeFile(filename, &c)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("parsing config file %v failed: %v", filename, err)
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			var keys []string
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			return Config{}, fmt.Errorf("config file %v: unknown settings %v", filename, strings.Join(keys, ", "))
+		}
+
+		if c.Default != "" {
+			cfg.Default = c.Default
+		}
+
+		for name, p := range c.Profiles {
+			cfg.Profiles[name] = p
+		}
+	}
+
+	return cfg, nil
+}
+
+// Profile returns the profile with the name, or the default profile if name
+// is empty. If no profile is selected at all, an empty profile is returned.
+func (cfg Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = cfg.Default
+	}
+
+	if name == "" {
+		return Profile{}, nil
+	}
+
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			return Profile{}, fmt.Errorf("profile %q not found, no profiles are configured", name)
+		}
+		return Profile{}, fmt.Errorf("profile %q not found, available are: %v", name, strings.Join(names, ", "))
+	}
+
+	return p, nil
+}
+
+// profileEnv lists the flags whose default is taken from an environment
+// variable. When the variable is set, it takes precedence over the profile.
+var profileEnv = map[string]string{
+	"database": "VMAIL_DB",
+	"scheme":   "VMAIL_HASH_SCHEME",
+}
+
+// apply sets all flags of cmd which were not passed on the command line or
+// set with an environment variable to the values from the profile. The order
+// of precedence is flag, environment variable, profile.
+func (p Profile) apply(cmd *cobra.Command) error {
+	var settings = map[string]string{
+		"database":           p.Database,
+		"scheme":             p.HashScheme,
+		"output":             p.Output,
+		"audit-log-file":     p.AuditLogFile,
+		"breached-passwords": p.PasswordPolicy.BreachedPasswords,
+	}
+
+	// the quota is only a default for new mailboxes
+	if p.Quota != nil && cmd == cmdCreateMailbox {
+		settings["quota"] = strconv.FormatUint(*p.Quota, 10)
+	}
+	if p.PasswordPolicy.MinLength != nil {
+		settings["password-min-length"] = strconv.Itoa(*p.PasswordPolicy.MinLength)
+	}
+	if p.PasswordPolicy.MaxLength != nil {
+		settings["password-max-length"] = strconv.Itoa(*p.PasswordPolicy.MaxLength)
+	}
+	if p.PasswordPolicy.MinClasses != nil {
+		settings["password-min-classes"] = strconv.Itoa(*p.PasswordPolicy.MinClasses)
+	}
+	if p.PasswordPolicy.AllowAddress != nil {
+		settings["password-allow-address"] = strconv.FormatBool(*p.PasswordPolicy.AllowAddress)
+	}
+
+	for name, value := range settings {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed || value == "" {
+			continue
+		}
+
+		if env, ok := profileEnv[name]; ok && os.Getenv(env) != "" {
+			continue
+		}
+
+		err := f.Value.Set(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %v: %v", value, name, err)
+		}
+	}
+
+	return nil
+}
+
+// readSecret returns the first line of the file.
+func readSecret(filename string) (string, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimRight(strings.SplitN(string(buf), "\n", 2)[0], "\r")
+	if secret == "" {
+		return "", fmt.Errorf("file %v is empty", filename)
+	}
+
+	return secret, nil
+}
+
+// dsnWithPassword returns the connection string with the password added.
+func dsnWithPassword(dsn, password string) (string, error) {
+	d, source := parseDSN(dsn)
+
+	switch d.Name {
+	case dialectMySQL.Name:
+		cfg, err := mysql.ParseDSN(source)
+		if err != nil {
+			return "", err
+		}
+		cfg.Passwd = password
+		return cfg.FormatDSN(), nil
+	case dialectPostgres.Name:
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+		return u.String(), nil
+	}
+
+	return "", errors.New("a password can only be used for MySQL and PostgreSQL")
+}
+
+// dsnWithoutPassword returns the connection string with the password
+// replaced, so that it can be recorded. If the connection string cannot be
+// parsed, it is replaced completely.
+func dsnWithoutPassword(dsn string) string {
+	d, source := parseDSN(dsn)
+
+	switch d.Name {
+	case dialectMySQL.Name:
+		cfg, err := mysql.ParseDSN(source)
+		if err != nil {
+			return redactValue(dsn)
+		}
+		userinfo := cfg.User + ":" + cfg.Passwd + "@"
+		if cfg.Passwd == "" {
+			return dsn
+		}
+		if !strings.HasPrefix(source, userinfo) {
+			return redactValue(dsn)
+		}
+		// keep the scheme removed by parseDSN
+		return strings.TrimSuffix(dsn, source) + cfg.User + ":" + redactValue(cfg.Passwd) + "@" +
+			strings.TrimPrefix(source, userinfo)
+	case dialectPostgres.Name:
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return redactValue(dsn)
+		}
+		if _, ok := u.User.Password(); !ok {
+			return dsn
+		}
+		redacted := strings.Replace(dsn, "//"+u.User.String()+"@", "//"+u.User.Username()+":"+redactValue("")+"@", 1)
+		if redacted == dsn {
+			return redactValue(dsn)
+		}
+		return redacted
+	}
+
+	return dsn
+}
@@ -4,36 +4,241 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"sort"
 
 	"github.com/jmoiron/sqlx"
 )
 
-// Domain is a domain for receiving email.
+// errAlreadyExists is returned when an object cannot be created because it
+// is already present in the database.
+var errAlreadyExists = errors.New("already exists")
+
+// checkExists translates unique constraint violations to errAlreadyExists.
+func (db *DB) checkExists(err error) error {
+	if err != nil && db.dialect.IsUniqueViolation(err) {
+		return errAlreadyExists
+	}
+	return err
+}
+
+// Domain is a domain for receiving email. When a domain is disabled, all
+// mailboxes and aliases in it are suspended. When a domain is send-only, all
+// mailboxes in it are send-only and all aliases are suspended.
 type Domain struct {
-	ID     int
-	Domain string
+	ID       int
+	Domain   string
+	Enabled  bool
+	Sendonly bool
+	DomainLimits
+}
+
+// receives returns whether the domain accepts mail for its aliases.
+func (d Domain) receives() bool {
+	return d.Enabled && !d.Sendonly
+}
+
+// DomainLimits restricts the number of mailboxes and aliases and the sum of
+// the quotas of all mailboxes in a domain, zero means unlimited.
+// DefaultQuota is used for new mailboxes created without a quota.
+type DomainLimits struct {
+	MaxAccounts  int `db:"max_accounts"`
+	MaxAliases   int `db:"max_aliases"`
+	MaxQuota     int `db:"max_quota"`
+	DefaultQuota int `db:"default_quota"`
+}
+
+// limited returns whether any limit is set, the default quota is not a limit.
+func (l DomainLimits) limited() bool {
+	return l.MaxAccounts > 0 || l.MaxAliases > 0 || l.MaxQuota > 0
+}
+
+// queryer is implemented by both *sqlx.DB and *sqlx.Tx.
+type queryer interface {
+	sqlx.Execer
+	sqlx.Queryer
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
 }
 
 // DB stores domains, accounts and aliases.
 type DB struct {
-	*sqlx.DB
+	db      *sqlx.DB
+	tx      *sqlx.Tx
+	q       queryer
+	dialect dialect
+
+	// audit is shared with all transactions, pending collects the audit
+	// entries within a transaction until it is committed.
+	audit   *auditLog
+	pending *[]auditEntry
+
+	// syncTargets collects the target domains whose alias domains must be
+	// updated before the transaction is committed.
+	syncTargets map[string]bool
+}
+
+// ConnectDB opens a connection to the database described by dsn, the
+// database engine is selected by the scheme (see parseDSN).
+func ConnectDB(dsn string) (*DB, error) {
+	d, source := parseDSN(dsn)
+	db, err := sqlx.Connect(d.Name, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{db: db, q: db, dialect: d, audit: &auditLog{}}, nil
+}
+
+// Close closes the database connection.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// WithTx runs fn in a transaction, which is committed if fn returns nil and
+// rolled back otherwise. All changes must be made through the DB passed to
+// fn. If db is already a transaction, fn is run within it.
+func (db *DB) WithTx(fn func(tx *DB) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err == nil {
+		err = tx.flushAliasDomains()
+	}
+	if err == nil && db.audit.filename == "" {
+		err = tx.writeAudit(*tx.pending)
+	}
+
+	// The file can only be written after the commit, so make sure it can be
+	// opened before. This leaves only write errors after the commit.
+	var auditFile *os.File
+	if err == nil && db.audit.filename != "" && len(*tx.pending) > 0 {
+		auditFile, err = openAuditFile(db.audit.filename)
+		if err != nil {
+			err = fmt.Errorf("opening audit log failed, no changes applied: %v", err)
+		}
+	}
+
+	if err != nil {
+		_ = tx.tx.Rollback()
+		return err
+	}
+
+	err = tx.tx.Commit()
+	if err != nil {
+		if auditFile != nil {
+			_ = auditFile.Close()
+		}
+		return err
+	}
+
+	if auditFile != nil {
+		err = writeAuditFile(auditFile, *tx.pending)
+		if err != nil {
+			return fmt.Errorf("changes were applied, but writing audit log %v failed: %v", db.audit.filename, err)
+		}
+	}
+
+	return nil
 }
 
-// ConnectDB opens a connection to the database.
-func ConnectDB(driver, source string) (*DB, error) {
-	db, err := sqlx.Connect(driver, source)
+// begin starts a new transaction.
+func (db *DB) begin() (*DB, error) {
+	sqltx, err := db.db.Beginx()
 	if err != nil {
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	return &DB{db: db.db, tx: sqltx, q: sqltx, dialect: db.dialect, audit: db.audit, pending: &[]auditEntry{}, syncTargets: make(map[string]bool)}, nil
+}
+
+// BeginDryRun starts a transaction which is never committed, all changes
+// made through the returned DB must be reverted with Rollback.
+func (db *DB) BeginDryRun() (*DB, error) {
+	return db.begin()
 }
 
+// Rollback reverts all changes made in the transaction started by
+// BeginDryRun and returns the audit entries describing them.
+func (db *DB) Rollback() ([]auditEntry, error) {
+	if db.tx == nil {
+		return nil, errors.New("no transaction active")
+	}
+
+	// update the alias domains so that the changes are included
+	err := db.flushAliasDomains()
+	if err != nil {
+		_ = db.tx.Rollback()
+		return nil, err
+	}
+
+	err = db.tx.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := *db.pending
+	db.tx, db.q, db.pending, db.syncTargets = nil, db.db, nil, nil
+	return entries, nil
+}
+
+// exec runs the query with '?' placeholders.
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.q.Exec(db.dialect.Rebind(query), args...)
+}
+
+// get runs the query with '?' placeholders and scans the single row into dest.
+func (db *DB) get(dest interface{}, query string, args ...interface{}) error {
+	return db.q.Get(dest, db.dialect.Rebind(query), args...)
+}
+
+// selectRows runs the query with '?' placeholders and scans all rows into dest.
+func (db *DB) selectRows(dest interface{}, query string, args ...interface{}) error {
+	return db.q.Select(dest, db.dialect.Rebind(query), args...)
+}
+
+// The columns for the tables, listed explicitly so that additional columns do
+// not break scanning rows into structs.
+const (
+	domainColumns      = "id, domain, enabled, sendonly, max_accounts, max_aliases, max_quota, default_quota"
+	accountColumns     = "id, username, domain, password, quota, enabled, sendonly, password_reset, suspended, domain_sendonly"
+	aliasColumns       = "id, source_username, source_domain, destination_username, destination_domain, blacklisted, enabled, suspended, mirrored"
+	tlsPolicyColumns   = "id, domain, policy, params"
+	aliasDomainColumns = "id, alias_domain, target_domain"
+)
+
 // CreateDomain creates a new domain d.
 func (db *DB) CreateDomain(name string) error {
-	_, err := db.Exec("INSERT INTO domains (domain) VALUES (?)", name)
+	return db.createDomain(Domain{Domain: name, Enabled: true})
+}
+
+func (db *DB) createDomain(d Domain) error {
+	return db.WithTx(func(tx *DB) error {
+		err := tx.insertDomain(d)
+		if err != nil {
+			return err
+		}
+
+		return tx.record("create", "domain "+d.Domain, nil, newDomainRecord(d))
+	})
+}
+
+// insertDomain adds the row for the domain without recording it.
+func (db *DB) insertDomain(d Domain) error {
+	_, err := db.exec(`INSERT INTO domains
+		(domain, enabled, sendonly, max_accounts, max_aliases, max_quota, default_quota)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.Domain, db.dialect.Bool(d.Enabled), db.dialect.Bool(d.Sendonly),
+		d.MaxAccounts, d.MaxAliases, d.MaxQuota, d.DefaultQuota)
 	if err != nil {
-		return err
+		return db.checkExists(err)
 	}
 
 	return nil
@@ -42,7 +247,7 @@ func (db *DB) CreateDomain(name string) error {
 // FindDomain looks for a domain with the given name in the database.
 func (db *DB) FindDomain(name string) (Domain, error) {
 	var d Domain
-	err := db.Get(&d, "SELECT * from domains WHERE domain = ?", name)
+	err := db.get(&d, "SELECT "+domainColumns+" FROM domains WHERE domain = ?", name)
 	if err != nil {
 		return Domain{}, fmt.Errorf("domain not found: %v", err)
 	}
@@ -50,10 +255,145 @@ func (db *DB) FindDomain(name string) (Domain, error) {
 	return d, nil
 }
 
+// domainState returns the domain, if it does not exist an enabled domain
+// which is not send-only is returned.
+func (db *DB) domainState(name string) (Domain, error) {
+	var d Domain
+	err := db.get(&d, "SELECT "+domainColumns+" FROM domains WHERE domain = ?", name)
+	if err == sql.ErrNoRows {
+		return Domain{Domain: name, Enabled: true}, nil
+	}
+
+	return d, err
+}
+
+// suspendState returns the values for the columns enabled and suspended of a
+// mailbox or alias which is enabled (or not) by itself, in a domain which is
+// enabled (or not).
+func suspendState(enabled, domainEnabled bool) (bool, bool) {
+	if domainEnabled {
+		return enabled, false
+	}
+
+	return false, enabled
+}
+
+// sendonlyState returns the values for the columns sendonly and
+// domain_sendonly of a mailbox which is send-only (or not) by itself, in a
+// domain which is send-only (or not).
+func sendonlyState(sendonly, domainSendonly bool) (bool, bool) {
+	if !domainSendonly {
+		return sendonly, false
+	}
+
+	return true, !sendonly
+}
+
+// SetDomainEnabled enables or disables the domain. Disabling it suspends all
+// enabled mailboxes and aliases in the domain, enabling it again restores
+// them. It returns the number of mailboxes and aliases which were changed.
+func (db *DB) SetDomainEnabled(name string, enabled bool) (DomainCascade, error) {
+	return db.setDomainFlag(name, "enabled", enabled, func(d *Domain) *bool { return &d.Enabled })
+}
+
+// SetDomainSendonly makes the domain send-only or lets it receive mail again.
+// In a send-only domain, all mailboxes are send-only and all aliases are
+// suspended, the previous state is restored when the domain receives mail
+// again. It returns the number of mailboxes and aliases which were changed.
+func (db *DB) SetDomainSendonly(name string, sendonly bool) (DomainCascade, error) {
+	return db.setDomainFlag(name, "sendonly", sendonly, func(d *Domain) *bool { return &d.Sendonly })
+}
+
+// setDomainFlag sets the boolean column of the domain to value and applies
+// the new state of the domain to all mailboxes and aliases in it. field
+// returns the struct field for the column.
+func (db *DB) setDomainFlag(name, column string, value bool, field func(*Domain) *bool) (DomainCascade, error) {
+	var c DomainCascade
+	err := db.WithTx(func(tx *DB) error {
+		d, err := tx.FindDomain(name)
+		if err != nil {
+			return err
+		}
+
+		if *field(&d) == value {
+			return nil
+		}
+
+		_, err = tx.exec("UPDATE domains SET "+column+" = ? WHERE id = ?", tx.dialect.Bool(value), d.ID)
+		if err != nil {
+			return err
+		}
+
+		before := d
+		*field(&d) = value
+		err = tx.record("update", "domain "+name, newDomainRecord(before), newDomainRecord(d))
+		if err != nil {
+			return err
+		}
+
+		c, err = tx.cascadeDomain(d)
+		return err
+	})
+	if err != nil {
+		return DomainCascade{}, err
+	}
+
+	return c, nil
+}
+
+// cascadeDomain updates the state of all mailboxes and aliases in the domain
+// d so that they match the state of the domain. It returns the number of
+// mailboxes and aliases which were changed.
+func (db *DB) cascadeDomain(d Domain) (DomainCascade, error) {
+	var c DomainCascade
+
+	accounts, err := db.FindAllAccounts(d.Domain)
+	if err != nil {
+		return DomainCascade{}, err
+	}
+
+	for _, a := range accounts {
+		cur := a
+		a.Enabled, a.Suspended = suspendState(a.IsEnabled(), d.Enabled)
+		a.Sendonly, a.DomainSendonly = sendonlyState(a.IsSendonly(), d.Sendonly)
+		if a == cur {
+			continue
+		}
+
+		err = db.UpdateAccount(a)
+		if err != nil {
+			return DomainCascade{}, fmt.Errorf("updating mailbox %v@%v failed: %v", a.Username, a.Domain, err)
+		}
+		c.Mailboxes++
+	}
+
+	aliases, err := db.FindAllAliases(d.Domain)
+	if err != nil {
+		return DomainCascade{}, err
+	}
+
+	for _, a := range aliases {
+		state, suspended := suspendState(a.IsEnabled(), d.receives())
+		if a.Enabled == state && a.Suspended == suspended {
+			continue
+		}
+
+		a.Enabled, a.Suspended = state, suspended
+		err = db.UpdateAlias(a)
+		if err != nil {
+			return DomainCascade{}, fmt.Errorf("updating alias %v failed: %v", a.Source(), err)
+		}
+		c.Aliases++
+	}
+
+	return c, nil
+}
+
 // FindAllDomains returns a list of all domains which contain name.
 func (db *DB) FindAllDomains(name string) ([]Domain, error) {
 	var ds []Domain
-	err := db.Select(&ds, "SELECT * from domains WHERE domain LIKE ? ORDER BY domain", "%"+name+"%")
+	err := db.selectRows(&ds, "SELECT "+domainColumns+" FROM domains WHERE "+
+		db.dialect.LikeExpr("domain")+" ORDER BY domain", likeContains(name))
 	if err != nil {
 		return nil, err
 	}
@@ -61,84 +401,392 @@ func (db *DB) FindAllDomains(name string) ([]Domain, error) {
 	return ds, nil
 }
 
-// DeleteDomain removes a domain, including all mailboxes and aliases.
-func (db *DB) DeleteDomain(name string) error {
-	// delete mailboxes
-	_, err := db.Exec("DELETE FROM accounts WHERE domain = ?", name)
+// SetDomainLimits replaces the limits of the domain. The current usage may
+// exceed the new limits, they are only enforced for new mailboxes and aliases
+// and when quotas are raised.
+func (db *DB) SetDomainLimits(name string, limits DomainLimits) error {
+	return db.WithTx(func(tx *DB) error {
+		d, err := tx.FindDomain(name)
+		if err != nil {
+			return err
+		}
+
+		if d.DomainLimits == limits {
+			return nil
+		}
+
+		_, err = tx.exec(`UPDATE domains
+			SET max_accounts = ?, max_aliases = ?, max_quota = ?, default_quota = ?
+			WHERE id = ?`,
+			limits.MaxAccounts, limits.MaxAliases, limits.MaxQuota, limits.DefaultQuota, d.ID)
+		if err != nil {
+			return err
+		}
+
+		before := d
+		d.DomainLimits = limits
+		return tx.record("update", "domain "+name, newDomainRecord(before), newDomainRecord(d))
+	})
+}
+
+// DomainUsage is the number of mailboxes and aliases in a domain and the sum
+// of the quotas of all mailboxes. Aliases are counted by source address,
+// aliases maintained for an alias domain are not included. Unlimited is the
+// number of mailboxes without a quota.
+type DomainUsage struct {
+	Accounts  int
+	Aliases   int
+	Quota     int
+	Unlimited int
+}
+
+// DomainUsage returns the usage of the domain.
+func (db *DB) DomainUsage(name string) (DomainUsage, error) {
+	var u DomainUsage
+
+	err := db.get(&u.Accounts, "SELECT COUNT(*) FROM accounts WHERE domain = ?", name)
 	if err != nil {
-		return fmt.Errorf("removing mailboxes for %v failed: %v", name, err)
+		return DomainUsage{}, err
 	}
 
-	// delete aliases
-	_, err = db.Exec("DELETE FROM aliases WHERE source_domain = ?", name)
+	err = db.get(&u.Quota, "SELECT COALESCE(SUM(quota), 0) FROM accounts WHERE domain = ?", name)
 	if err != nil {
-		return fmt.Errorf("removing aliases for %v failed: %v", name, err)
+		return DomainUsage{}, err
 	}
 
-	res, err := db.Exec("DELETE FROM domains WHERE domain = ?", name)
+	err = db.get(&u.Unlimited, "SELECT COUNT(*) FROM accounts WHERE domain = ? AND (quota = 0 OR quota IS NULL)", name)
 	if err != nil {
-		return err
+		return DomainUsage{}, err
+	}
+
+	var sources []sql.NullString
+	err = db.selectRows(&sources, "SELECT DISTINCT source_username FROM aliases WHERE source_domain = ? AND mirrored = ?",
+		name, db.dialect.Bool(false))
+	if err != nil {
+		return DomainUsage{}, err
+	}
+	u.Aliases = len(sources)
+
+	return u, nil
+}
+
+// accountUsage returns the usage of the mailbox a in its domain.
+func accountUsage(a Account) DomainUsage {
+	u := DomainUsage{Accounts: 1, Quota: a.Quota}
+	if a.Quota == 0 {
+		u.Unlimited = 1
+	}
+
+	return u
+}
+
+// checkDomainLimits returns an error if the usage of the domain d grows by
+// delta beyond one of its limits. A domain which already exceeds a lowered
+// limit can still be changed as long as the usage does not grow. The current
+// usage is only queried for domains with limits.
+func (db *DB) checkDomainLimits(d Domain, delta DomainUsage) error {
+	if !d.limited() {
+		return nil
 	}
 
-	n, err := res.RowsAffected()
+	if d.MaxQuota > 0 && delta.Unlimited > 0 {
+		return fmt.Errorf("mailboxes in domain %v need a quota, the total quota is limited to %d bytes", d.Domain, d.MaxQuota)
+	}
+
+	grows := (d.MaxAccounts > 0 && delta.Accounts > 0) ||
+		(d.MaxAliases > 0 && delta.Aliases > 0) ||
+		(d.MaxQuota > 0 && delta.Quota > 0)
+	if !grows {
+		return nil
+	}
+
+	u, err := db.DomainUsage(d.Domain)
 	if err != nil {
 		return err
 	}
 
-	if n == 0 {
-		return errors.New("not found")
+	if d.MaxAccounts > 0 && delta.Accounts > 0 && u.Accounts+delta.Accounts > d.MaxAccounts {
+		return fmt.Errorf("domain %v allows at most %d mailboxes", d.Domain, d.MaxAccounts)
+	}
+
+	if d.MaxAliases > 0 && delta.Aliases > 0 && u.Aliases+delta.Aliases > d.MaxAliases {
+		return fmt.Errorf("domain %v allows at most %d aliases", d.Domain, d.MaxAliases)
+	}
+
+	if d.MaxQuota > 0 && delta.Quota > 0 && u.Quota+delta.Quota > d.MaxQuota {
+		return fmt.Errorf("total quota of domain %v would be %d bytes, the limit is %d bytes", d.Domain, u.Quota+delta.Quota, d.MaxQuota)
 	}
 
 	return nil
 }
 
-// DeleteMailbox removes a mailbox.
-func (db *DB) DeleteMailbox(user, domain string) error {
-	res, err := db.Exec("DELETE FROM accounts WHERE username = ? AND domain = ?", user, domain)
-	if err != nil {
+// checkAliasLimits returns an error if the alias a adds a source address to
+// the domain d beyond its limit. Aliases are counted by source address, the
+// alias with the ID a.ID itself is not counted.
+func (db *DB) checkAliasLimits(d Domain, a Alias) error {
+	if d.MaxAliases == 0 || a.Mirrored {
+		return nil
+	}
+
+	query := "SELECT COUNT(*) FROM aliases WHERE source_domain = ? AND mirrored = ? AND id <> ? AND source_username "
+	args := []interface{}{a.SourceDomain, db.dialect.Bool(false), a.ID}
+	if a.SourceUsername.Valid {
+		query += "= ?"
+		args = append(args, a.SourceUsername.String)
+	} else {
+		query += "IS NULL"
+	}
+
+	var n int
+	err := db.get(&n, query, args...)
+	if err != nil || n > 0 {
 		return err
 	}
 
-	n, err := res.RowsAffected()
+	return db.checkDomainLimits(d, DomainUsage{Aliases: 1})
+}
+
+// DomainCascade lists the number of objects affected by a change to a
+// domain: the objects removed together with it (PreviewDeleteDomain), or the
+// mailboxes and aliases whose state changed when the domain was enabled,
+// disabled, made send-only or receives mail again (SetDomainEnabled,
+// SetDomainSendonly). ForeignAliases is only set when the domain is removed.
+type DomainCascade struct {
+	// Mailboxes in the domain.
+	Mailboxes int
+
+	// Aliases for addresses in the domain.
+	Aliases int
+
+	// ForeignAliases are aliases in other domains pointing to the domain.
+	ForeignAliases int
+}
+
+// PreviewDeleteDomain returns the number of mailboxes and aliases which are
+// removed by DeleteDomain.
+func (db *DB) PreviewDeleteDomain(name string) (DomainCascade, error) {
+	var c DomainCascade
+
+	_, err := db.FindDomain(name)
+	if err != nil {
+		return DomainCascade{}, err
+	}
+
+	err = db.get(&c.Mailboxes, "SELECT COUNT(*) FROM accounts WHERE domain = ?", name)
 	if err != nil {
-		return err
+		return DomainCascade{}, err
 	}
 
-	if n == 0 {
-		return errors.New("not found")
+	err = db.get(&c.Aliases, "SELECT COUNT(*) FROM aliases WHERE source_domain = ?", name)
+	if err != nil {
+		return DomainCascade{}, err
 	}
 
-	return nil
+	err = db.get(&c.ForeignAliases, `SELECT COUNT(*) FROM aliases
+		WHERE destination_domain = ? AND source_domain != ?`, name, name)
+	if err != nil {
+		return DomainCascade{}, err
+	}
+
+	return c, nil
 }
 
-// Account is a mailbox.
+// DeleteDomain removes a domain, including all mailboxes and aliases, and
+// all aliases in other domains pointing to it. Either all or none of the
+// objects are removed.
+func (db *DB) DeleteDomain(name string) error {
+	return db.WithTx(func(tx *DB) error {
+		var d Domain
+		err := tx.get(&d, "SELECT "+domainColumns+" FROM domains WHERE domain = ?", name)
+		if err == sql.ErrNoRows {
+			return errors.New("not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		// remove the alias domains for the domain and the domain itself if it
+		// is an alias domain
+		aliasDomains, err := tx.FindAliasDomainsFor(name)
+		if err != nil {
+			return err
+		}
+
+		if ad, err := tx.FindAliasDomain(name); err == nil {
+			aliasDomains = append(aliasDomains, ad)
+		}
+
+		for _, ad := range aliasDomains {
+			err = tx.DeleteAliasDomain(ad.AliasDomain)
+			if err != nil {
+				return fmt.Errorf("removing alias domain %v failed: %v", ad.AliasDomain, err)
+			}
+		}
+
+		accounts, err := tx.FindAllAccounts(name)
+		if err != nil {
+			return err
+		}
+
+		var aliases []Alias
+		err = tx.selectRows(&aliases, "SELECT "+aliasColumns+" FROM aliases WHERE source_domain = ? OR destination_domain = ?", name, name)
+		if err != nil {
+			return err
+		}
+
+		// delete mailboxes
+		_, err = tx.exec("DELETE FROM accounts WHERE domain = ?", name)
+		if err != nil {
+			return fmt.Errorf("removing mailboxes for %v failed: %v", name, err)
+		}
+
+		// delete aliases
+		_, err = tx.exec("DELETE FROM aliases WHERE source_domain = ? OR destination_domain = ?", name, name)
+		if err != nil {
+			return fmt.Errorf("removing aliases for %v failed: %v", name, err)
+		}
+
+		res, err := tx.exec("DELETE FROM domains WHERE domain = ?", name)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return errors.New("not found")
+		}
+
+		for _, a := range accounts {
+			err = tx.record("delete", "mailbox "+a.Username+"@"+a.Domain, auditAccount(a), nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		// aliases in other domains pointing to the domain were removed, so
+		// their alias domains must be updated
+		var sources []string
+		for _, a := range aliases {
+			err = tx.recordAlias("delete", &a, nil)
+			if err != nil {
+				return err
+			}
+
+			if a.SourceDomain != name {
+				sources = append(sources, a.SourceDomain)
+			}
+		}
+
+		err = tx.record("delete", "domain "+name, newDomainRecord(d), nil)
+		if err != nil {
+			return err
+		}
+
+		return tx.syncAliasDomains(sources...)
+	})
+}
+
+// DeleteMailbox removes a mailbox.
+func (db *DB) DeleteMailbox(user, domain string) error {
+	return db.WithTx(func(tx *DB) error {
+		cur, err := tx.findAccount(user, domain)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec("DELETE FROM accounts WHERE id = ?", cur.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.record("delete", "mailbox "+user+"@"+domain, auditAccount(cur), nil)
+		if err != nil {
+			return err
+		}
+
+		return tx.syncAliasDomains(domain)
+	})
+}
+
+// Account is a mailbox. PasswordReset is set when the password must be
+// changed with 'vmail password'. Suspended is set when the mailbox is only
+// disabled because the domain is disabled, DomainSendonly is set when the
+// mailbox is only send-only because the domain is send-only.
 type Account struct {
-	ID       int
-	Username string
-	Domain   string
-	Password string
-	Quota    int
-	Enabled  bool
-	Sendonly bool
+	ID             int
+	Username       string
+	Domain         string
+	Password       string
+	Quota          int
+	Enabled        bool
+	Sendonly       bool
+	PasswordReset  bool `db:"password_reset"`
+	Suspended      bool
+	DomainSendonly bool `db:"domain_sendonly"`
+}
+
+// IsEnabled returns whether the mailbox is enabled by itself, regardless of
+// the domain.
+func (a Account) IsEnabled() bool {
+	return a.Enabled || a.Suspended
+}
+
+// IsSendonly returns whether the mailbox is send-only by itself, regardless
+// of the domain.
+func (a Account) IsSendonly() bool {
+	return a.Sendonly && !a.DomainSendonly
 }
 
 // CreateAccount creates a new mailbox for the domain d.
 func (db *DB) CreateAccount(a Account) error {
-	_, err := db.Exec(`INSERT INTO accounts
-		(username, domain, password, quota, enabled, sendonly)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		a.Username, a.Domain, a.Password, a.Quota, a.Enabled, a.Sendonly)
-	if err != nil {
-		return err
-	}
+	return db.WithTx(func(tx *DB) error {
+		ad, err := tx.FindAliasDomain(a.Domain)
+		if err == nil {
+			return fmt.Errorf("domain %v is an alias domain for %v", a.Domain, ad.TargetDomain)
+		}
 
-	return nil
+		d, err := tx.domainState(a.Domain)
+		if err != nil {
+			return err
+		}
+		a.Enabled, a.Suspended = suspendState(a.IsEnabled(), d.Enabled)
+		a.Sendonly, a.DomainSendonly = sendonlyState(a.IsSendonly(), d.Sendonly)
+
+		if a.Quota == 0 {
+			a.Quota = d.DefaultQuota
+		}
+
+		err = tx.checkDomainLimits(d, accountUsage(a))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec(`INSERT INTO accounts
+			(username, domain, password, quota, enabled, sendonly, suspended, domain_sendonly)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.Username, a.Domain, a.Password, a.Quota, tx.dialect.Bool(a.Enabled), tx.dialect.Bool(a.Sendonly),
+			tx.dialect.Bool(a.Suspended), tx.dialect.Bool(a.DomainSendonly))
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		err = tx.record("create", "mailbox "+a.Username+"@"+a.Domain, nil, auditAccount(a))
+		if err != nil {
+			return err
+		}
+
+		return tx.syncAliasDomains(a.Domain)
+	})
 }
 
 // FindAllAccounts returns a list of all accounts for a domain.
 func (db *DB) FindAllAccounts(domain string) ([]Account, error) {
 	var accounts []Account
-	err := db.Select(&accounts, "SELECT * from accounts WHERE domain = ?", domain)
+	err := db.selectRows(&accounts, "SELECT "+accountColumns+" FROM accounts WHERE domain = ?", domain)
 	if err != nil {
 		return nil, err
 	}
@@ -149,7 +797,7 @@ func (db *DB) FindAllAccounts(domain string) ([]Account, error) {
 // FindAccount returns the account.
 func (db *DB) FindAccount(username, domain string) (Account, error) {
 	var a Account
-	err := db.Select(&a, "SELECT * from accounts WHERE username = ? AND domain = ?", username, domain)
+	err := db.get(&a, "SELECT "+accountColumns+" FROM accounts WHERE username = ? AND domain = ?", username, domain)
 	if err != nil {
 		return Account{}, err
 	}
@@ -157,26 +805,127 @@ func (db *DB) FindAccount(username, domain string) (Account, error) {
 	return a, nil
 }
 
-// UpdateAccountPassword sets the password for the given account.
-func (db *DB) UpdateAccountPassword(username, domain, passwordhash string) error {
-	res, err := db.Exec("UPDATE accounts SET password = ? WHERE username = ? AND domain = ?", passwordhash, username, domain)
-	if err != nil {
-		return err
+// findAccount returns the account, or an error "not found" if it does not
+// exist.
+func (db *DB) findAccount(username, domain string) (Account, error) {
+	a, err := db.FindAccount(username, domain)
+	if err == sql.ErrNoRows {
+		return Account{}, errors.New("not found")
 	}
 
-	n, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
+	return a, err
+}
 
-	if n == 0 {
-		return errors.New("not found")
+// findAccountByID returns the account with the ID.
+func (db *DB) findAccountByID(id int) (Account, error) {
+	var a Account
+	err := db.get(&a, "SELECT "+accountColumns+" FROM accounts WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return Account{}, errors.New("not found")
 	}
 
-	return nil
+	return a, err
 }
 
-// Alias forwards email to another destination.
+// UpdateAccountPassword sets the password for the given account and clears
+// the mark for a password reset.
+func (db *DB) UpdateAccountPassword(username, domain, passwordhash string) error {
+	return db.WithTx(func(tx *DB) error {
+		cur, err := tx.findAccount(username, domain)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec("UPDATE accounts SET password = ?, password_reset = ? WHERE id = ?",
+			passwordhash, tx.dialect.Bool(false), cur.ID)
+		if err != nil {
+			return err
+		}
+
+		a := cur
+		a.Password, a.PasswordReset = passwordhash, false
+		return tx.recordAccount(cur, a)
+	})
+}
+
+// MarkPasswordReset marks the account so that the password must be changed
+// with 'vmail password'.
+func (db *DB) MarkPasswordReset(username, domain string) error {
+	return db.WithTx(func(tx *DB) error {
+		cur, err := tx.findAccount(username, domain)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec("UPDATE accounts SET password_reset = ? WHERE id = ?", tx.dialect.Bool(true), cur.ID)
+		if err != nil {
+			return err
+		}
+
+		a := cur
+		a.PasswordReset = true
+		return tx.recordAccount(cur, a)
+	})
+}
+
+// UpdateAccount updates all fields of the account with the ID a.ID.
+func (db *DB) UpdateAccount(a Account) error {
+	return db.WithTx(func(tx *DB) error {
+		cur, err := tx.findAccountByID(a.ID)
+		if err != nil {
+			return err
+		}
+
+		d, err := tx.domainState(a.Domain)
+		if err != nil {
+			return err
+		}
+
+		delta := accountUsage(a)
+		if cur.Domain == a.Domain {
+			old := accountUsage(cur)
+			delta = DomainUsage{
+				Quota:     delta.Quota - old.Quota,
+				Unlimited: delta.Unlimited - old.Unlimited,
+			}
+		}
+
+		err = tx.checkDomainLimits(d, delta)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec(`UPDATE accounts
+			SET
+				username = ?, domain = ?, password = ?,
+				quota = ?, enabled = ?, sendonly = ?,
+				password_reset = ?, suspended = ?, domain_sendonly = ?
+			WHERE id = ?`,
+			a.Username, a.Domain, a.Password,
+			a.Quota, tx.dialect.Bool(a.Enabled), tx.dialect.Bool(a.Sendonly),
+			tx.dialect.Bool(a.PasswordReset), tx.dialect.Bool(a.Suspended), tx.dialect.Bool(a.DomainSendonly),
+			a.ID,
+		)
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		err = tx.recordAccount(cur, a)
+		if err != nil {
+			return err
+		}
+
+		if cur.Username == a.Username && cur.Domain == a.Domain {
+			return nil
+		}
+
+		return tx.syncAliasDomains(cur.Domain, a.Domain)
+	})
+}
+
+// Alias forwards email to another destination. Suspended is set when the
+// alias is only disabled because the source domain is disabled, Mirrored is
+// set for aliases maintained for an alias domain.
 type Alias struct {
 	ID                  int            `db:"id"`
 	SourceUsername      sql.NullString `db:"source_username"`
@@ -185,95 +934,125 @@ type Alias struct {
 	DestinationDomain   string         `db:"destination_domain"`
 	Blacklisted         bool           `db:"blacklisted"`
 	Enabled             bool           `db:"enabled"`
+	Suspended           bool           `db:"suspended"`
+	Mirrored            bool           `db:"mirrored"`
+}
+
+// IsEnabled returns whether the alias is enabled by itself, regardless of
+// the domain.
+func (a Alias) IsEnabled() bool {
+	return a.Enabled || a.Suspended
+}
+
+// Source returns the source address of the alias, with "*" as the local
+// part for catch-all aliases.
+func (a Alias) Source() string {
+	if !a.SourceUsername.Valid {
+		return "*@" + a.SourceDomain
+	}
+	return a.SourceUsername.String + "@" + a.SourceDomain
+}
+
+// Destination returns the destination address of the alias.
+func (a Alias) Destination() string {
+	return a.DestinationUsername + "@" + a.DestinationDomain
 }
 
 // CreateAlias creates a new alias for the domain d.
 func (db *DB) CreateAlias(a Alias) error {
-	_, err := db.Exec(`INSERT INTO aliases
-		(source_username, source_domain, destination_username, destination_domain, blacklisted, enabled)
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		a.SourceUsername, a.SourceDomain, a.DestinationUsername, a.DestinationDomain, a.Blacklisted, a.Enabled)
-	if err != nil {
-		return err
-	}
+	return db.WithTx(func(tx *DB) error {
+		d, err := tx.domainState(a.SourceDomain)
+		if err != nil {
+			return err
+		}
+		a.Enabled, a.Suspended = suspendState(a.IsEnabled(), d.receives())
 
-	return nil
+		err = tx.checkAliasLimits(d, a)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec(`INSERT INTO aliases
+			(source_username, source_domain, destination_username, destination_domain, blacklisted, enabled, suspended, mirrored)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.SourceUsername, a.SourceDomain, a.DestinationUsername, a.DestinationDomain,
+			tx.dialect.Bool(a.Blacklisted), tx.dialect.Bool(a.Enabled), tx.dialect.Bool(a.Suspended),
+			tx.dialect.Bool(a.Mirrored))
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		err = tx.recordAlias("create", nil, &a)
+		if err != nil {
+			return err
+		}
+
+		return tx.syncAliasDomains(a.SourceDomain)
+	})
 }
 
 // DeleteAlias removes an alias.
 func (db *DB) DeleteAlias(srcuser sql.NullString, srcdomain, dstuser, dstdomain string) error {
-	var (
-		res sql.Result
-		err error
-	)
-
 	if srcuser.Valid {
-		res, err = db.Exec(`DELETE FROM aliases WHERE
-			source_username = ? AND
+		return db.deleteAliases(`source_username = ? AND
 			source_domain = ?
 			AND destination_username = ?
 			AND destination_domain = ?`,
 			srcuser, srcdomain, dstuser, dstdomain)
-	} else {
-		res, err = db.Exec(`DELETE FROM aliases WHERE
-			source_username IS NULL AND
-			source_domain = ?
-			AND destination_username = ?
-			AND destination_domain = ?`,
-			srcdomain, dstuser, dstdomain)
-	}
-	if err != nil {
-		return err
-	}
-
-	n, err := res.RowsAffected()
-	if err != nil {
-		return err
 	}
 
-	if n == 0 {
-		return errors.New("not found")
-	}
-
-	return nil
+	return db.deleteAliases(`source_username IS NULL AND
+		source_domain = ?
+		AND destination_username = ?
+		AND destination_domain = ?`,
+		srcdomain, dstuser, dstdomain)
 }
 
 // DeleteAliasAll removes an alias.
 func (db *DB) DeleteAliasAll(srcuser sql.NullString, srcdomain string) error {
-	var (
-		res sql.Result
-		err error
-	)
-
 	if srcuser.Valid {
-		res, err = db.Exec(`DELETE FROM aliases WHERE
-			source_username = ? AND source_domain = ?`,
-			srcuser, srcdomain)
-	} else {
-		res, err = db.Exec(`DELETE FROM aliases WHERE
-			source_username IS NULL AND source_domain = ?`,
-			srcdomain)
-	}
-	if err != nil {
-		return err
+		return db.deleteAliases("source_username = ? AND source_domain = ?", srcuser, srcdomain)
 	}
 
-	n, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
+	return db.deleteAliases("source_username IS NULL AND source_domain = ?", srcdomain)
+}
 
-	if n == 0 {
-		return errors.New("not found")
-	}
+// deleteAliases removes all aliases matching the condition, it returns an
+// error if there are none.
+func (db *DB) deleteAliases(cond string, args ...interface{}) error {
+	return db.WithTx(func(tx *DB) error {
+		var aliases []Alias
+		err := tx.selectRows(&aliases, "SELECT "+aliasColumns+" FROM aliases WHERE "+cond, args...)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		if len(aliases) == 0 {
+			return errors.New("not found")
+		}
+
+		_, err = tx.exec("DELETE FROM aliases WHERE "+cond, args...)
+		if err != nil {
+			return err
+		}
+
+		var domains []string
+		for _, a := range aliases {
+			err = tx.recordAlias("delete", &a, nil)
+			if err != nil {
+				return err
+			}
+			domains = append(domains, a.SourceDomain)
+		}
+
+		return tx.syncAliasDomains(domains...)
+	})
 }
 
 // FindAlias returns a list of aliases for a domain.
 func (db *DB) FindAliases(localPart, domain string) ([]Alias, error) {
 	var aliases []Alias
-	err := db.Select(&aliases, `SELECT * from aliases
+	err := db.selectRows(&aliases, `SELECT `+aliasColumns+` FROM aliases
 		WHERE source_username = ? and source_domain = ?
 		ORDER BY source_username, destination_username, destination_domain`,
 		localPart, domain)
@@ -287,7 +1066,7 @@ func (db *DB) FindAliases(localPart, domain string) ([]Alias, error) {
 // FindAllAliases returns a list of all aliases for a domain.
 func (db *DB) FindAllAliases(domain string) ([]Alias, error) {
 	var aliases []Alias
-	err := db.Select(&aliases, `SELECT * from aliases
+	err := db.selectRows(&aliases, `SELECT `+aliasColumns+` FROM aliases
 		WHERE source_domain = ?
 		ORDER BY source_username, destination_username, destination_domain`,
 		domain)
@@ -300,28 +1079,735 @@ func (db *DB) FindAllAliases(domain string) ([]Alias, error) {
 
 // UpdateAlias updates an alias.
 func (db *DB) UpdateAlias(a Alias) error {
-	res, err := db.Exec(`UPDATE aliases
-		SET
-			source_username = ?, source_domain = ?,
-			destination_username = ?, destination_domain = ?,
-			blacklisted = ?, enabled = ?
-		WHERE id = ?`,
-		a.SourceUsername, a.SourceDomain,
-		a.DestinationUsername, a.DestinationDomain,
-		a.Blacklisted, a.Enabled,
-		a.ID,
-	)
+	return db.WithTx(func(tx *DB) error {
+		var cur Alias
+		err := tx.get(&cur, "SELECT "+aliasColumns+" FROM aliases WHERE id = ?", a.ID)
+		if err == sql.ErrNoRows {
+			return errors.New("not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		// only a new source address can exceed the limit
+		if !cur.Mirrored && a.Source() != cur.Source() {
+			var d Domain
+			d, err = tx.domainState(a.SourceDomain)
+			if err != nil {
+				return err
+			}
+
+			err = tx.checkAliasLimits(d, a)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.exec(`UPDATE aliases
+			SET
+				source_username = ?, source_domain = ?,
+				destination_username = ?, destination_domain = ?,
+				blacklisted = ?, enabled = ?, suspended = ?
+			WHERE id = ?`,
+			a.SourceUsername, a.SourceDomain,
+			a.DestinationUsername, a.DestinationDomain,
+			tx.dialect.Bool(a.Blacklisted), tx.dialect.Bool(a.Enabled), tx.dialect.Bool(a.Suspended),
+			a.ID,
+		)
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		err = tx.recordAlias("update", &cur, &a)
+		if err != nil {
+			return err
+		}
+
+		return tx.syncAliasDomains(cur.SourceDomain, a.SourceDomain)
+	})
+}
+
+// FindAliasesTo returns a list of all aliases with the destination user@domain.
+func (db *DB) FindAliasesTo(user, domain string) ([]Alias, error) {
+	var aliases []Alias
+	err := db.selectRows(&aliases, `SELECT `+aliasColumns+` FROM aliases
+		WHERE destination_username = ? AND destination_domain = ?
+		ORDER BY source_domain, source_username`,
+		user, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// RenameMailbox changes the address of a mailbox, the password hash, quota
+// and flags are kept. All aliases pointing to the old address are changed to
+// point to the new address. If forward is set, an alias from the old to the
+// new address is created. It returns the aliases which were changed.
+func (db *DB) RenameMailbox(oldUser, oldDomain, newUser, newDomain string, forward bool) ([]Alias, error) {
+	var changed []Alias
+	err := db.WithTx(func(tx *DB) error {
+		a, err := tx.FindAccount(oldUser, oldDomain)
+		if err != nil {
+			return fmt.Errorf("mailbox %v@%v not found: %v", oldUser, oldDomain, err)
+		}
+
+		d, err := tx.FindDomain(newDomain)
+		if err != nil {
+			return err
+		}
+
+		a.Username, a.Domain = newUser, newDomain
+		a.Enabled, a.Suspended = suspendState(a.IsEnabled(), d.Enabled)
+		a.Sendonly, a.DomainSendonly = sendonlyState(a.IsSendonly(), d.Sendonly)
+		err = tx.UpdateAccount(a)
+		if err != nil {
+			return fmt.Errorf("renaming mailbox failed: %v", err)
+		}
+
+		aliases, err := tx.FindAliasesTo(oldUser, oldDomain)
+		if err != nil {
+			return err
+		}
+
+		existing, err := tx.FindAliasesTo(newUser, newDomain)
+		if err != nil {
+			return err
+		}
+
+		present := make(map[string]bool)
+		for _, alias := range existing {
+			present[alias.Source()] = true
+		}
+
+		for _, alias := range aliases {
+			if alias.Mirrored {
+				// updated together with the aliases in the target domain
+				continue
+			}
+
+			if present[alias.Source()] {
+				// the alias already points to the new address
+				err = tx.DeleteAlias(alias.SourceUsername, alias.SourceDomain, oldUser, oldDomain)
+			} else {
+				alias.DestinationUsername, alias.DestinationDomain = newUser, newDomain
+				err = tx.UpdateAlias(alias)
+			}
+			if err != nil {
+				return fmt.Errorf("updating alias %v failed: %v", alias.Source(), err)
+			}
+
+			changed = append(changed, alias)
+		}
+
+		if forward {
+			err = tx.CreateAlias(Alias{
+				SourceUsername:      sql.NullString{String: oldUser, Valid: true},
+				SourceDomain:        oldDomain,
+				DestinationUsername: newUser,
+				DestinationDomain:   newDomain,
+				Enabled:             true,
+			})
+			if err != nil {
+				return fmt.Errorf("creating alias %v@%v failed: %v", oldUser, oldDomain, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changed, nil
+}
+
+// FindAliasesToDomain returns a list of all aliases with a destination in
+// the domain.
+func (db *DB) FindAliasesToDomain(domain string) ([]Alias, error) {
+	var aliases []Alias
+	err := db.selectRows(&aliases, `SELECT `+aliasColumns+` FROM aliases
+		WHERE destination_domain = ?
+		ORDER BY source_domain, source_username, destination_username`,
+		domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// DomainRename lists the rows changed by RenameDomain.
+type DomainRename struct {
+	// Accounts in the domain.
+	Accounts []Account
+
+	// Aliases with the source or a destination in the domain.
+	Aliases []Alias
+
+	// Redirects are the aliases created from the old to the new domain.
+	Redirects []Alias
+}
+
+// PreviewRenameDomain returns the rows changed by RenameDomain, with the old
+// values.
+func (db *DB) PreviewRenameDomain(oldName, newName string, redirect bool) (DomainRename, error) {
+	var r DomainRename
+
+	_, err := db.FindDomain(oldName)
+	if err != nil {
+		return DomainRename{}, err
+	}
+
+	_, err = db.FindDomain(newName)
+	if err == nil {
+		return DomainRename{}, fmt.Errorf("domain %v already exists", newName)
+	}
+
+	r.Accounts, err = db.FindAllAccounts(oldName)
+	if err != nil {
+		return DomainRename{}, err
+	}
+
+	r.Aliases, err = db.FindAllAliases(oldName)
+	if err != nil {
+		return DomainRename{}, err
+	}
+
+	incoming, err := db.FindAliasesToDomain(oldName)
+	if err != nil {
+		return DomainRename{}, err
+	}
+
+	for _, a := range incoming {
+		// aliases within the domain are already in the list
+		if a.SourceDomain != oldName {
+			r.Aliases = append(r.Aliases, a)
+		}
+	}
+
+	if !redirect {
+		return r, nil
+	}
+
+	// redirect all addresses (except for the catch-all alias) to the new domain
+	users := make(map[string]bool)
+	addRedirect := func(user string) {
+		if users[user] {
+			return
+		}
+		users[user] = true
+
+		r.Redirects = append(r.Redirects, Alias{
+			SourceUsername:      sql.NullString{String: user, Valid: true},
+			SourceDomain:        oldName,
+			DestinationUsername: user,
+			DestinationDomain:   newName,
+			Enabled:             true,
+		})
+	}
+
+	for _, a := range r.Accounts {
+		addRedirect(a.Username)
+	}
+
+	for _, a := range r.Aliases {
+		if a.SourceDomain == oldName && a.SourceUsername.Valid {
+			addRedirect(a.SourceUsername.String)
+		}
+	}
+
+	return r, nil
+}
+
+// RenameDomain moves the domain and all mailboxes and aliases to a new
+// name, aliases in other domains pointing to the domain are changed as well.
+// If redirect is set, the old domain is kept with an alias for each address
+// pointing to the same address in the new domain.
+func (db *DB) RenameDomain(oldName, newName string, redirect bool) error {
+	return db.WithTx(func(tx *DB) error {
+		r, err := tx.PreviewRenameDomain(oldName, newName, redirect)
+		if err != nil {
+			return err
+		}
+
+		old, err := tx.FindDomain(oldName)
+		if err != nil {
+			return err
+		}
+
+		renamed := old
+		renamed.Domain = newName
+
+		// The foreign keys of the other tables reference domains.domain
+		// without ON UPDATE CASCADE, so the name cannot be changed in place
+		// while rows still point to it. The new row is inserted first, then
+		// all rows are moved over and the old row is removed. The new row
+		// then takes over the ID of the old one.
+		if redirect {
+			err = tx.createDomain(renamed)
+		} else {
+			err = tx.insertDomain(renamed)
+		}
+		if err != nil {
+			return fmt.Errorf("creating domain %v failed: %v", newName, err)
+		}
+
+		for _, query := range []string{
+			"UPDATE accounts SET domain = ? WHERE domain = ?",
+			"UPDATE aliases SET source_domain = ? WHERE source_domain = ?",
+			"UPDATE aliases SET destination_domain = ? WHERE destination_domain = ?",
+			"UPDATE alias_domains SET alias_domain = ? WHERE alias_domain = ?",
+			"UPDATE alias_domains SET target_domain = ? WHERE target_domain = ?",
+		} {
+			_, err = tx.exec(query, newName, oldName)
+			if err != nil {
+				return tx.checkExists(err)
+			}
+		}
+
+		if !redirect {
+			_, err = tx.exec("DELETE FROM domains WHERE domain = ?", oldName)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.exec("UPDATE domains SET id = ? WHERE domain = ?", old.ID, newName)
+			if err != nil {
+				return err
+			}
+
+			err = tx.record("rename", "domain "+oldName, newDomainRecord(old), newDomainRecord(renamed))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, a := range r.Accounts {
+			renamed := a
+			renamed.Domain = newName
+			err = tx.recordAccount(a, renamed)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, a := range r.Aliases {
+			renamed := a
+			if renamed.SourceDomain == oldName {
+				renamed.SourceDomain = newName
+			}
+			if renamed.DestinationDomain == oldName {
+				renamed.DestinationDomain = newName
+			}
+			err = tx.recordAlias("update", &a, &renamed)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the limits moved to the new domain, they must not restrict the
+		// redirects in the old one
+		if redirect && old.DomainLimits != (DomainLimits{}) {
+			err = tx.SetDomainLimits(oldName, DomainLimits{})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, a := range r.Redirects {
+			err = tx.CreateAlias(a)
+			if err != nil {
+				return fmt.Errorf("creating alias %v failed: %v", a.Source(), err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// TLSPolicy configures how Postfix uses TLS when delivering mail to a domain.
+type TLSPolicy struct {
+	ID     int            `db:"id"`
+	Domain string         `db:"domain"`
+	Policy string         `db:"policy"`
+	Params sql.NullString `db:"params"`
+}
+
+// CreateTLSPolicy creates a new TLS policy.
+func (db *DB) CreateTLSPolicy(p TLSPolicy) error {
+	return db.WithTx(func(tx *DB) error {
+		_, err := tx.exec("INSERT INTO tlspolicies (domain, policy, params) VALUES (?, ?, ?)",
+			p.Domain, p.Policy, p.Params)
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		return tx.record("create", "TLS policy "+p.Domain, nil, auditTLSPolicy(p))
+	})
+}
+
+// FindAllTLSPolicies returns a list of all TLS policies for domains which
+// contain name.
+func (db *DB) FindAllTLSPolicies(name string) ([]TLSPolicy, error) {
+	var ps []TLSPolicy
+	err := db.selectRows(&ps, "SELECT "+tlsPolicyColumns+" FROM tlspolicies WHERE "+
+		db.dialect.LikeExpr("domain")+" ORDER BY domain", likeContains(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// FindTLSPolicy returns the TLS policy for the domain.
+func (db *DB) FindTLSPolicy(domain string) (TLSPolicy, error) {
+	var p TLSPolicy
+	err := db.get(&p, "SELECT "+tlsPolicyColumns+" FROM tlspolicies WHERE domain = ?", domain)
+	if err == sql.ErrNoRows {
+		return TLSPolicy{}, errors.New("not found")
+	}
+	if err != nil {
+		return TLSPolicy{}, err
+	}
+
+	return p, nil
+}
+
+// UpdateTLSPolicy updates the TLS policy with the ID p.ID.
+func (db *DB) UpdateTLSPolicy(p TLSPolicy) error {
+	return db.WithTx(func(tx *DB) error {
+		var cur TLSPolicy
+		err := tx.get(&cur, "SELECT "+tlsPolicyColumns+" FROM tlspolicies WHERE id = ?", p.ID)
+		if err == sql.ErrNoRows {
+			return errors.New("not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec("UPDATE tlspolicies SET domain = ?, policy = ?, params = ? WHERE id = ?",
+			p.Domain, p.Policy, p.Params, p.ID)
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		return tx.record("update", "TLS policy "+p.Domain, auditTLSPolicy(cur), auditTLSPolicy(p))
+	})
+}
+
+// DeleteTLSPolicy removes the TLS policy for the domain.
+func (db *DB) DeleteTLSPolicy(domain string) error {
+	return db.WithTx(func(tx *DB) error {
+		cur, err := tx.FindTLSPolicy(domain)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec("DELETE FROM tlspolicies WHERE id = ?", cur.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.record("delete", "TLS policy "+domain, auditTLSPolicy(cur), nil)
+	})
+}
+
+// AliasDomain redirects all addresses in a domain to the same addresses in
+// the target domain. For each mailbox and alias in the target domain, an
+// alias with Mirrored set is maintained in the alias domain.
+type AliasDomain struct {
+	ID           int    `db:"id"`
+	AliasDomain  string `db:"alias_domain"`
+	TargetDomain string `db:"target_domain"`
+}
+
+// CreateAliasDomain makes alias an alias domain for target. The domain alias
+// is created if it does not exist yet, it must not contain any mailboxes.
+func (db *DB) CreateAliasDomain(alias, target string) error {
+	return db.WithTx(func(tx *DB) error {
+		if alias == target {
+			return errors.New("a domain cannot be an alias domain for itself")
+		}
+
+		_, err := tx.FindDomain(target)
+		if err != nil {
+			return err
+		}
+
+		if ad, err := tx.FindAliasDomain(target); err == nil {
+			return fmt.Errorf("%v is an alias domain for %v itself", target, ad.TargetDomain)
+		}
+
+		list, err := tx.FindAliasDomainsFor(alias)
+		if err != nil {
+			return err
+		}
+
+		if len(list) > 0 {
+			return fmt.Errorf("%v is the target of the alias domain %v", alias, list[0].AliasDomain)
+		}
+
+		var n int
+		err = tx.get(&n, "SELECT COUNT(*) FROM domains WHERE domain = ?", alias)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			err = tx.CreateDomain(alias)
+			if err != nil {
+				return fmt.Errorf("creating domain %v failed: %v", alias, err)
+			}
+		}
+
+		err = tx.get(&n, "SELECT COUNT(*) FROM accounts WHERE domain = ?", alias)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return fmt.Errorf("domain %v has %d mailboxes, an alias domain cannot have mailboxes", alias, n)
+		}
+
+		ad := AliasDomain{AliasDomain: alias, TargetDomain: target}
+		_, err = tx.exec("INSERT INTO alias_domains (alias_domain, target_domain) VALUES (?, ?)", alias, target)
+		if err != nil {
+			return tx.checkExists(err)
+		}
+
+		err = tx.record("create", "alias domain "+alias, nil, newAliasDomainRecord(ad))
+		if err != nil {
+			return err
+		}
+
+		return tx.syncAliasDomain(ad)
+	})
+}
+
+// FindAliasDomain returns the alias domain with the name.
+func (db *DB) FindAliasDomain(name string) (AliasDomain, error) {
+	var ad AliasDomain
+	err := db.get(&ad, "SELECT "+aliasDomainColumns+" FROM alias_domains WHERE alias_domain = ?", name)
+	if err == sql.ErrNoRows {
+		return AliasDomain{}, errors.New("not found")
+	}
+	if err != nil {
+		return AliasDomain{}, err
+	}
+
+	return ad, nil
+}
+
+// FindAliasDomainsFor returns all alias domains for the target domain.
+func (db *DB) FindAliasDomainsFor(target string) ([]AliasDomain, error) {
+	var list []AliasDomain
+	err := db.selectRows(&list, "SELECT "+aliasDomainColumns+" FROM alias_domains WHERE target_domain = ? ORDER BY alias_domain", target)
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// FindAllAliasDomains returns all alias domains.
+func (db *DB) FindAllAliasDomains() ([]AliasDomain, error) {
+	var list []AliasDomain
+	err := db.selectRows(&list, "SELECT "+aliasDomainColumns+" FROM alias_domains ORDER BY alias_domain")
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// DeleteAliasDomain removes the alias domain together with all mirrored
+// aliases, the domain itself is kept.
+func (db *DB) DeleteAliasDomain(alias string) error {
+	return db.WithTx(func(tx *DB) error {
+		ad, err := tx.FindAliasDomain(alias)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.exec("DELETE FROM alias_domains WHERE id = ?", ad.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.record("delete", "alias domain "+alias, newAliasDomainRecord(ad), nil)
+		if err != nil {
+			return err
+		}
+
+		var n int
+		err = tx.get(&n, "SELECT COUNT(*) FROM aliases WHERE source_domain = ? AND mirrored = ?", alias, tx.dialect.Bool(true))
+		if err != nil || n == 0 {
+			return err
+		}
+
+		return tx.deleteAliases("source_domain = ? AND mirrored = ?", alias, tx.dialect.Bool(true))
+	})
+}
+
+// syncAliasDomains marks the alias domains for the target domains to be
+// updated. Within a transaction this happens once before it is committed, so
+// many changes to a target domain do not rebuild its alias domains each time.
+func (db *DB) syncAliasDomains(targets ...string) error {
+	if db.tx == nil {
+		return db.syncAliasDomainsFor(targets)
+	}
+
+	for _, target := range targets {
+		db.syncTargets[target] = true
+	}
+
+	return nil
+}
+
+// flushAliasDomains updates the alias domains for all target domains marked
+// by syncAliasDomains. Updating an alias domain changes aliases, so this
+// repeats until no targets are left.
+func (db *DB) flushAliasDomains() error {
+	for len(db.syncTargets) > 0 {
+		var targets []string
+		for target := range db.syncTargets {
+			targets = append(targets, target)
+			delete(db.syncTargets, target)
+		}
+		sort.Strings(targets)
+
+		err := db.syncAliasDomainsFor(targets)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncAliasDomainsFor updates the mirrored aliases of all alias domains for
+// the target domains.
+func (db *DB) syncAliasDomainsFor(targets []string) error {
+	done := make(map[string]bool)
+	for _, target := range targets {
+		if done[target] {
+			continue
+		}
+		done[target] = true
+
+		list, err := db.FindAliasDomainsFor(target)
+		if err != nil {
+			return err
+		}
+
+		for _, ad := range list {
+			err = db.syncAliasDomain(ad)
+			if err != nil {
+				return fmt.Errorf("updating alias domain %v failed: %v", ad.AliasDomain, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// syncAliasDomain creates, updates and removes the mirrored aliases in the
+// alias domain, so that each address in the target domain (mailboxes and
+// aliases) is redirected to from the alias domain. The catch-all alias is
+// copied with the same destinations.
+func (db *DB) syncAliasDomain(ad AliasDomain) error {
+	accounts, err := db.FindAllAccounts(ad.TargetDomain)
 	if err != nil {
 		return err
 	}
 
-	n, err := res.RowsAffected()
+	aliases, err := db.FindAllAliases(ad.TargetDomain)
 	if err != nil {
 		return err
 	}
 
-	if n == 0 {
-		return errors.New("not found")
+	desired := make(map[aliasKey]Alias)
+	add := func(a Alias) {
+		a.SourceDomain, a.Mirrored = ad.AliasDomain, true
+		desired[aliasKey{a.Source(), a.Destination()}] = a
+	}
+
+	for _, a := range accounts {
+		add(Alias{
+			SourceUsername:      sql.NullString{String: a.Username, Valid: true},
+			DestinationUsername: a.Username,
+			DestinationDomain:   a.Domain,
+			Enabled:             true,
+		})
+	}
+
+	for _, a := range aliases {
+		if !a.SourceUsername.Valid {
+			add(Alias{
+				DestinationUsername: a.DestinationUsername,
+				DestinationDomain:   a.DestinationDomain,
+				Blacklisted:         a.Blacklisted,
+				Enabled:             a.IsEnabled(),
+			})
+			continue
+		}
+
+		add(Alias{
+			SourceUsername:      a.SourceUsername,
+			DestinationUsername: a.SourceUsername.String,
+			DestinationDomain:   a.SourceDomain,
+			Enabled:             true,
+		})
+	}
+
+	existing, err := db.FindAllAliases(ad.AliasDomain)
+	if err != nil {
+		return err
+	}
+
+	d, err := db.domainState(ad.AliasDomain)
+	if err != nil {
+		return err
+	}
+
+	for _, cur := range existing {
+		key := aliasKey{cur.Source(), cur.Destination()}
+		want, ok := desired[key]
+		delete(desired, key)
+
+		switch {
+		case !cur.Mirrored:
+			// aliases created manually are kept as they are
+		case !ok:
+			err = db.DeleteAlias(cur.SourceUsername, cur.SourceDomain, cur.DestinationUsername, cur.DestinationDomain)
+		case cur.Blacklisted != want.Blacklisted || cur.IsEnabled() != want.IsEnabled():
+			cur.Blacklisted = want.Blacklisted
+			cur.Enabled, cur.Suspended = suspendState(want.IsEnabled(), d.receives())
+			err = db.UpdateAlias(cur)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var keys []aliasKey
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Source != keys[j].Source {
+			return keys[i].Source < keys[j].Source
+		}
+		return keys[i].Destination < keys[j].Destination
+	})
+
+	for _, key := range keys {
+		err = db.CreateAlias(desired[key])
+		if err != nil {
+			return fmt.Errorf("creating alias %v -> %v failed: %v", key.Source, key.Destination, err)
+		}
 	}
 
 	return nil
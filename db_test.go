@@ -0,0 +1,1021 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteDomainRollback(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAlias(Alias{
+		SourceUsername:      sql.NullString{String: "foo", Valid: true},
+		SourceDomain:        "example.com",
+		DestinationUsername: "bar",
+		DestinationDomain:   "example.org",
+		Enabled:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// example.org is not a domain in the database, so removing it fails and
+	// the alias pointing to it must not be removed
+	err = db.DeleteDomain("example.org")
+	if err == nil {
+		t.Fatal("removing a nonexistent domain did not return an error")
+	}
+
+	aliases, err := db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 {
+		t.Fatalf("want 1 alias after failed delete, got %d", len(aliases))
+	}
+
+	cascade, err := db.PreviewDeleteDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cascade != (DomainCascade{Aliases: 1}) {
+		t.Fatalf("unexpected cascade preview %+v", cascade)
+	}
+
+	err = db.DeleteDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err = db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 0 {
+		t.Fatalf("want no aliases after delete, got %d", len(aliases))
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAccount(Account{Username: "user", Domain: "example.com", Password: "{PLAIN}secret", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.MarkPasswordReset("user", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := db.FindAccount("user", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.PasswordReset {
+		t.Fatal("account is not marked for a password reset")
+	}
+
+	err = db.UpdateAccountPassword("user", "example.com", "{PLAIN}other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = db.FindAccount("user", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.PasswordReset {
+		t.Fatal("mark for a password reset was not removed by changing the password")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	db := newTestDB(t)
+
+	tx, err := db.BeginDryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.CreateAccount(Account{Username: "foo", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nested transactions are part of the dry run
+	err = tx.DeleteDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("want 4 changes, got %d: %v", len(entries), entries)
+	}
+
+	checkAuditLog(t, db, nil)
+
+	domains, err := db.FindAllDomains("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domains) != 0 {
+		t.Fatalf("domains were created in a dry run: %v", domains)
+	}
+}
+
+func TestSetDomainEnabled(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range []Account{
+		{Username: "active", Domain: "example.com", Password: testPasswordHash, Enabled: true},
+		{Username: "inactive", Domain: "example.com", Password: testPasswordHash, Enabled: false},
+	} {
+		err = db.CreateAccount(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.CreateAlias(Alias{
+		SourceUsername:      sql.NullString{String: "info", Valid: true},
+		SourceDomain:        "example.com",
+		DestinationUsername: "active",
+		DestinationDomain:   "example.com",
+		Enabled:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState := func(user string, enabled, suspended bool) {
+		t.Helper()
+
+		a, err := db.FindAccount(user, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Enabled != enabled || a.Suspended != suspended {
+			t.Errorf("mailbox %v: want enabled %v, suspended %v, got %v, %v",
+				user, enabled, suspended, a.Enabled, a.Suspended)
+		}
+	}
+
+	c, err := db.SetDomainEnabled("example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != (DomainCascade{Mailboxes: 1, Aliases: 1}) {
+		t.Errorf("unexpected number of suspended objects %+v", c)
+	}
+
+	// new mailboxes in a disabled domain are suspended as well
+	err = db.CreateAccount(Account{Username: "new", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState("active", false, true)
+	checkState("inactive", false, false)
+	checkState("new", false, true)
+
+	aliases, err := db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0].Enabled || !aliases[0].Suspended {
+		t.Errorf("alias not suspended: %+v", aliases)
+	}
+
+	_, err = db.SetDomainEnabled("example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState("active", true, false)
+	checkState("inactive", false, false)
+	checkState("new", true, false)
+
+	d, err := db.FindDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.Enabled {
+		t.Errorf("domain is still disabled")
+	}
+}
+
+func TestSetDomainSendonly(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range []Account{
+		{Username: "normal", Domain: "example.com", Password: testPasswordHash, Enabled: true},
+		{Username: "sender", Domain: "example.com", Password: testPasswordHash, Enabled: true, Sendonly: true},
+	} {
+		err = db.CreateAccount(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.CreateAlias(Alias{
+		SourceUsername:      sql.NullString{String: "info", Valid: true},
+		SourceDomain:        "example.com",
+		DestinationUsername: "normal",
+		DestinationDomain:   "example.com",
+		Enabled:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState := func(user string, sendonly, domainSendonly bool) {
+		t.Helper()
+
+		a, err := db.FindAccount(user, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Sendonly != sendonly || a.DomainSendonly != domainSendonly {
+			t.Errorf("mailbox %v: want send-only %v, domain send-only %v, got %v, %v",
+				user, sendonly, domainSendonly, a.Sendonly, a.DomainSendonly)
+		}
+	}
+
+	checkAlias := func(enabled, suspended bool) {
+		t.Helper()
+
+		aliases, err := db.FindAllAliases("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(aliases) != 1 || aliases[0].Enabled != enabled || aliases[0].Suspended != suspended {
+			t.Errorf("alias: want enabled %v, suspended %v, got %+v", enabled, suspended, aliases)
+		}
+	}
+
+	c, err := db.SetDomainSendonly("example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != (DomainCascade{Mailboxes: 1, Aliases: 1}) {
+		t.Errorf("unexpected number of changed objects %+v", c)
+	}
+
+	// new mailboxes in a send-only domain are send-only as well
+	err = db.CreateAccount(Account{Username: "new", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState("normal", true, true)
+	checkState("sender", true, false)
+	checkState("new", true, true)
+	checkAlias(false, true)
+
+	// disabling and enabling the domain keeps the alias suspended
+	_, err = db.SetDomainEnabled("example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.SetDomainEnabled("example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState("normal", true, true)
+	checkAlias(false, true)
+
+	_, err = db.SetDomainSendonly("example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkState("normal", false, false)
+	checkState("sender", true, false)
+	checkState("new", false, false)
+	checkAlias(true, false)
+}
+
+func TestAliasDomain(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, name := range []string{"example.com", "example.de"} {
+		err := db.CreateDomain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.CreateAccount(Account{Username: "user", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range []Alias{
+		{SourceUsername: sql.NullString{String: "info", Valid: true}, DestinationUsername: "user"},
+		{DestinationUsername: "user"},
+	} {
+		a.SourceDomain, a.DestinationDomain, a.Enabled = "example.com", "example.com", true
+		err = db.CreateAlias(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkMirrored := func(want ...string) {
+		t.Helper()
+
+		aliases, err := db.FindAllAliases("example.de")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, a := range aliases {
+			if !a.Mirrored {
+				t.Errorf("alias %v -> %v is not marked as mirrored", a.Source(), a.Destination())
+			}
+			got = append(got, a.Source()+" -> "+a.Destination())
+		}
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong aliases in alias domain, want:\n  %v\ngot:\n  %v", want, got)
+		}
+	}
+
+	err = db.CreateAliasDomain("example.de", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMirrored(
+		"*@example.de -> user@example.com",
+		"info@example.de -> info@example.com",
+		"user@example.de -> user@example.com",
+	)
+
+	err = db.CreateAccount(Account{Username: "other", Domain: "example.de", Password: testPasswordHash, Enabled: true})
+	if err == nil {
+		t.Errorf("creating a mailbox in an alias domain succeeded")
+	}
+
+	err = db.CreateAccount(Account{Username: "new", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.DeleteMailbox("user", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMirrored(
+		"*@example.de -> user@example.com",
+		"info@example.de -> info@example.com",
+		"new@example.de -> new@example.com",
+	)
+
+	err = db.CreateAliasDomain("example.com", "example.de")
+	if err == nil {
+		t.Errorf("creating a loop of alias domains succeeded")
+	}
+
+	err = db.DeleteAliasDomain("example.de")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkMirrored()
+
+	_, err = db.FindDomain("example.de")
+	if err != nil {
+		t.Errorf("domain was removed together with the alias domain: %v", err)
+	}
+}
+
+func TestAliasDomainSyncOnCommit(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, name := range []string{"example.com", "example.de"} {
+		err := db.CreateDomain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.CreateAliasDomain("example.de", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countMirrored := func(db *DB) int {
+		t.Helper()
+
+		aliases, err := db.FindAllAliases("example.de")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(aliases)
+	}
+
+	err = db.WithTx(func(tx *DB) error {
+		for _, user := range []string{"alice", "bob", "carol"} {
+			err := tx.CreateAccount(Account{Username: user, Domain: "example.com", Password: testPasswordHash, Enabled: true})
+			if err != nil {
+				return err
+			}
+		}
+
+		// the alias domain is only updated before the commit
+		if n := countMirrored(tx); n != 0 {
+			t.Errorf("alias domain was updated within the transaction, %d aliases", n)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countMirrored(db); n != 3 {
+		t.Errorf("want 3 mirrored aliases after the commit, got %d", n)
+	}
+
+	// a dry run includes the changes to the alias domain
+	tx, err := db.BeginDryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.CreateAccount(Account{Username: "dave", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var objects []string
+	for _, e := range entries {
+		objects = append(objects, e.Action+" "+e.Object)
+	}
+
+	want := []string{"create mailbox dave@example.com", "create alias dave@example.de -> dave@example.com"}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("wrong audit entries in dry run, want %v, got %v", want, objects)
+	}
+}
+
+func TestDeleteDomainAliasDomain(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, name := range []string{"example.com", "example.de", "example.net"} {
+		err := db.CreateDomain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.CreateAccount(Account{Username: "user", Domain: "example.net", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAlias(Alias{
+		SourceUsername:      sql.NullString{String: "team", Valid: true},
+		SourceDomain:        "example.com",
+		DestinationUsername: "user",
+		DestinationDomain:   "example.net",
+		Enabled:             true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAliasDomain("example.de", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.DeleteDomain("example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the alias in the alias domain pointed to the removed alias
+	for _, name := range []string{"example.com", "example.de"} {
+		aliases, err := db.FindAllAliases(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(aliases) != 0 {
+			t.Errorf("aliases left in %v: %+v", name, aliases)
+		}
+	}
+}
+
+func TestDomainLimits(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SetDomainLimits("example.com", DomainLimits{MaxAccounts: 2, MaxAliases: 1, MaxQuota: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createAccount := func(user string, quota int) error {
+		return db.CreateAccount(Account{Username: user, Domain: "example.com", Password: testPasswordHash, Quota: quota, Enabled: true})
+	}
+
+	err = createAccount("unlimited", 0)
+	if err == nil {
+		t.Errorf("creating a mailbox without quota succeeded")
+	}
+
+	for _, user := range []string{"one", "two"} {
+		err = createAccount(user, 400)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = createAccount("three", 100)
+	if err == nil {
+		t.Errorf("creating more mailboxes than allowed succeeded")
+	}
+
+	a, err := db.FindAccount("one", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Quota = 700
+	err = db.UpdateAccount(a)
+	if err == nil {
+		t.Errorf("raising the quota above the limit succeeded")
+	}
+
+	// lowering the limit below the usage does not prevent other changes
+	err = db.SetDomainLimits("example.com", DomainLimits{MaxAccounts: 1, MaxAliases: 1, MaxQuota: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Quota, a.Sendonly = 300, true
+	err = db.UpdateAccount(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// aliases are counted by source address
+	for _, dest := range []string{"one", "two"} {
+		err = db.CreateAlias(Alias{
+			SourceUsername:      sql.NullString{String: "info", Valid: true},
+			SourceDomain:        "example.com",
+			DestinationUsername: dest,
+			DestinationDomain:   "example.com",
+			Enabled:             true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.CreateAlias(Alias{
+		SourceUsername:      sql.NullString{String: "sales", Valid: true},
+		SourceDomain:        "example.com",
+		DestinationUsername: "one",
+		DestinationDomain:   "example.com",
+		Enabled:             true,
+	})
+	if err == nil {
+		t.Errorf("creating more aliases than allowed succeeded")
+	}
+
+	aliases, err := db.FindAllAliases("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := aliases[0]
+	alias.Enabled = false
+	err = db.UpdateAlias(alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias.SourceUsername.String = "sales"
+	err = db.UpdateAlias(alias)
+	if err == nil {
+		t.Errorf("changing an alias to a new source address beyond the limit succeeded")
+	}
+
+	usage, err := db.DomainUsage("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage != (DomainUsage{Accounts: 2, Aliases: 1, Quota: 700}) {
+		t.Errorf("wrong usage %+v", usage)
+	}
+}
+
+func TestDefaultQuota(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SetDomainLimits("example.com", DomainLimits{MaxQuota: 1000, DefaultQuota: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for user, quota := range map[string]int{"default": 0, "explicit": 500} {
+		err = db.CreateAccount(Account{Username: user, Domain: "example.com", Password: testPasswordHash, Quota: quota, Enabled: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for user, want := range map[string]int{"default": 300, "explicit": 500} {
+		a, err := db.FindAccount(user, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Quota != want {
+			t.Errorf("mailbox %v: want quota %d, got %d", user, want, a.Quota)
+		}
+	}
+
+	// the default quota counts against the limit
+	err = db.CreateAccount(Account{Username: "third", Domain: "example.com", Password: testPasswordHash, Enabled: true})
+	if err == nil || !strings.Contains(err.Error(), "would be 1100 bytes") {
+		t.Errorf("want error for exceeded total quota, got %v", err)
+	}
+}
+
+// aliasSources returns the sorted sources of all aliases to user@domain.
+func aliasSources(t testing.TB, db *DB, user, domain string) []string {
+	aliases, err := db.FindAliasesTo(user, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sources []string
+	for _, a := range aliases {
+		sources = append(sources, a.Source())
+	}
+	sort.Strings(sources)
+
+	return sources
+}
+
+func TestRenameMailbox(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, name := range []string{"example.com", "example.org"} {
+		err := db.CreateDomain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.CreateAccount(Account{Username: "alice", Domain: "example.com", Password: testPasswordHash, Quota: 500, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range []Alias{
+		{SourceUsername: sql.NullString{String: "info", Valid: true}, DestinationUsername: "alice", DestinationDomain: "example.com"},
+		{SourceUsername: sql.NullString{String: "team", Valid: true}, DestinationUsername: "alice", DestinationDomain: "example.com"},
+		// already points to the new address
+		{SourceUsername: sql.NullString{String: "team", Valid: true}, DestinationUsername: "alice", DestinationDomain: "example.org"},
+	} {
+		a.SourceDomain, a.Enabled = "example.com", true
+		err = db.CreateAlias(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := db.RenameMailbox("alice", "example.com", "alice", "example.org", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 2 {
+		t.Errorf("want 2 changed aliases, got %+v", changed)
+	}
+
+	_, err = db.FindAccount("alice", "example.com")
+	if err == nil {
+		t.Error("old mailbox still exists")
+	}
+
+	a, err := db.FindAccount("alice", "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Quota != 500 || a.Password != testPasswordHash || !a.Enabled {
+		t.Errorf("mailbox not moved with all settings: %+v", a)
+	}
+
+	if sources := aliasSources(t, db, "alice", "example.com"); len(sources) != 0 {
+		t.Errorf("aliases still point to the old address: %v", sources)
+	}
+
+	want := []string{"alice@example.com", "info@example.com", "team@example.com"}
+	if sources := aliasSources(t, db, "alice", "example.org"); !reflect.DeepEqual(sources, want) {
+		t.Errorf("wrong aliases to the new address, want %v, got %v", want, sources)
+	}
+}
+
+func TestRenameMailboxExisting(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CreateDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		err = db.CreateAccount(Account{Username: user, Domain: "example.com", Password: testPasswordHash, Enabled: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.RenameMailbox("alice", "example.com", "bob", "example.com", false)
+	if err == nil {
+		t.Fatal("renaming to an existing mailbox did not fail")
+	}
+
+	_, err = db.FindAccount("alice", "example.com")
+	if err != nil {
+		t.Errorf("mailbox was removed after the failed rename: %v", err)
+	}
+}
+
+// setupRenameDomain creates the domain old.example with a mailbox, aliases
+// and an alias domain pointing to it.
+func setupRenameDomain(t testing.TB, db *DB) {
+	for _, name := range []string{"old.example", "other.example", "alias.example"} {
+		err := db.CreateDomain(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.SetDomainLimits("old.example", DomainLimits{MaxAccounts: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateAccount(Account{Username: "alice", Domain: "old.example", Password: testPasswordHash, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range []Alias{
+		{SourceUsername: sql.NullString{String: "info", Valid: true}, SourceDomain: "old.example"},
+		{SourceDomain: "old.example"},
+		{SourceUsername: sql.NullString{String: "ext", Valid: true}, SourceDomain: "other.example"},
+	} {
+		a.DestinationUsername, a.DestinationDomain, a.Enabled = "alice", "old.example", true
+		err = db.CreateAlias(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.CreateAliasDomain("alias.example", "old.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreviewRenameDomain(t *testing.T) {
+	db := newTestDB(t)
+	setupRenameDomain(t, db)
+
+	_, err := db.PreviewRenameDomain("old.example", "other.example", false)
+	if err == nil {
+		t.Error("renaming to an existing domain did not fail")
+	}
+
+	for _, redirect := range []bool{false, true} {
+		r, err := db.PreviewRenameDomain("old.example", "new.example", redirect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(r.Accounts) != 1 || r.Accounts[0].Username != "alice" {
+			t.Errorf("wrong accounts %+v", r.Accounts)
+		}
+
+		var aliases []string
+		for _, a := range r.Aliases {
+			aliases = append(aliases, a.Source())
+		}
+		sort.Strings(aliases)
+
+		want := []string{"*@alias.example", "*@old.example", "alice@alias.example", "ext@other.example", "info@alias.example", "info@old.example"}
+		if !reflect.DeepEqual(aliases, want) {
+			t.Errorf("wrong aliases, want %v, got %v", want, aliases)
+		}
+
+		var redirects []string
+		for _, a := range r.Redirects {
+			redirects = append(redirects, a.Source()+" -> "+a.Destination())
+		}
+
+		want = nil
+		if redirect {
+			want = []string{"alice@old.example -> alice@new.example", "info@old.example -> info@new.example"}
+		}
+		if !reflect.DeepEqual(redirects, want) {
+			t.Errorf("redirect %v: wrong redirects, want %v, got %v", redirect, want, redirects)
+		}
+	}
+}
+
+func TestRenameDomain(t *testing.T) {
+	db := newTestDB(t)
+	setupRenameDomain(t, db)
+
+	old, err := db.FindDomain("old.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RenameDomain("old.example", "new.example", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.FindDomain("old.example")
+	if err == nil {
+		t.Error("old domain still exists")
+	}
+
+	d, err := db.FindDomain("new.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.ID != old.ID || d.MaxAccounts != 10 || !d.Enabled {
+		t.Errorf("domain was not renamed in place, old %+v, new %+v", old, d)
+	}
+
+	want := []string{"*@alias.example", "*@new.example", "alice@alias.example", "ext@other.example", "info@new.example"}
+	if sources := aliasSources(t, db, "alice", "new.example"); !reflect.DeepEqual(sources, want) {
+		t.Errorf("wrong aliases to the new address, want %v, got %v", want, sources)
+	}
+
+	// the alias domain now mirrors the new domain
+	want = []string{"info@alias.example"}
+	if sources := aliasSources(t, db, "info", "new.example"); !reflect.DeepEqual(sources, want) {
+		t.Errorf("wrong aliases to info@new.example, want %v, got %v", want, sources)
+	}
+
+	ads, err := db.FindAllAliasDomains()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ads) != 1 || ads[0].TargetDomain != "new.example" {
+		t.Errorf("alias domain not changed: %+v", ads)
+	}
+
+	entries, err := db.AuditLog(time.Time{}, ".example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var domainEntries []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Object, "domain ") && e.Object != "domain other.example" && e.Object != "domain alias.example" {
+			domainEntries = append(domainEntries, e.Action+" "+e.Object)
+		}
+	}
+
+	wantEntries := []string{"create domain old.example", "update domain old.example", "rename domain old.example"}
+	if !reflect.DeepEqual(domainEntries, wantEntries) {
+		t.Errorf("wrong audit log entries for the domain, want %v, got %v", wantEntries, domainEntries)
+	}
+}
+
+func TestRenameDomainRedirect(t *testing.T) {
+	db := newTestDB(t)
+	setupRenameDomain(t, db)
+
+	// the limits must not prevent the redirects in the old domain
+	limits := DomainLimits{MaxAccounts: 10, MaxAliases: 1}
+	err := db.SetDomainLimits("old.example", limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.RenameDomain("old.example", "new.example", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := db.FindDomain("old.example")
+	if err != nil {
+		t.Errorf("old domain was removed: %v", err)
+	}
+
+	if old.DomainLimits != (DomainLimits{}) {
+		t.Errorf("limits of the old domain were not removed: %+v", old.DomainLimits)
+	}
+
+	d, err := db.FindDomain("new.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.DomainLimits != limits {
+		t.Errorf("want limits %+v for the new domain, got %+v", limits, d.DomainLimits)
+	}
+
+	_, err = db.FindAccount("alice", "new.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"*@alias.example", "*@new.example", "alice@alias.example", "alice@old.example",
+		"ext@other.example", "info@new.example"}
+	if sources := aliasSources(t, db, "alice", "new.example"); !reflect.DeepEqual(sources, want) {
+		t.Errorf("wrong aliases to the new address, want %v, got %v", want, sources)
+	}
+
+	want = []string{"info@alias.example", "info@old.example"}
+	if sources := aliasSources(t, db, "info", "new.example"); !reflect.DeepEqual(sources, want) {
+		t.Errorf("wrong aliases to info@new.example, want %v, got %v", want, sources)
+	}
+}
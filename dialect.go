@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	mysql "github.com/Go-SQL-Driver/MySQL"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect describes the differences between the SQL database engines vmail
+// can work with.
+type dialect struct {
+	// Name is the name of the database/sql driver.
+	Name string
+
+	// BindType is the placeholder style used by the driver.
+	BindType int
+
+	// Like is the operator used for case-insensitive pattern matching.
+	Like string
+
+	// LikeEscape is appended to LIKE expressions so that backslash escapes
+	// the wildcard characters.
+	LikeEscape string
+
+	// Bool converts a bool to the value stored in boolean columns.
+	Bool func(bool) interface{}
+
+	// TableExists is a query which returns the number of tables with the
+	// name passed as the parameter.
+	TableExists string
+
+	// IsUniqueViolation reports whether err was returned because a unique
+	// constraint was violated.
+	IsUniqueViolation func(error) bool
+}
+
+// boolInt stores booleans as integers, for databases with boolean columns
+// mapped to TINYINT or INTEGER.
+func boolInt(b bool) interface{} {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var (
+	dialectMySQL = dialect{
+		Name:     "mysql",
+		BindType: sqlx.QUESTION,
+		Like:     "LIKE",
+		Bool:     boolInt,
+		TableExists: `SELECT COUNT(*) FROM information_schema.tables
+			WHERE table_schema = DATABASE() AND table_name = ?`,
+		IsUniqueViolation: func(err error) bool {
+			var e *mysql.MySQLError
+			// ER_DUP_ENTRY
+			return errors.As(err, &e) && e.Number == 1062
+		},
+	}
+
+	dialectSQLite = dialect{
+		Name:        "sqlite3",
+		BindType:    sqlx.QUESTION,
+		Like:        "LIKE",
+		LikeEscape:  ` ESCAPE '\'`,
+		Bool:        boolInt,
+		TableExists: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		IsUniqueViolation: func(err error) bool {
+			var e sqlite3.Error
+			return errors.As(err, &e) &&
+				(e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+		},
+	}
+
+	dialectPostgres = dialect{
+		Name:     "postgres",
+		BindType: sqlx.DOLLAR,
+		Like:     "ILIKE",
+		Bool: func(b bool) interface{} {
+			return b
+		},
+		TableExists: `SELECT COUNT(*) FROM information_schema.tables
+			WHERE table_schema = current_schema() AND table_name = ?`,
+		IsUniqueViolation: func(err error) bool {
+			var e *pq.Error
+			return errors.As(err, &e) && e.Code == "23505"
+		},
+	}
+)
+
+// Rebind converts the query from '?' placeholders to the ones the database
+// understands.
+func (d dialect) Rebind(query string) string {
+	return sqlx.Rebind(d.BindType, query)
+}
+
+// LikeExpr returns an expression which matches column against a pattern
+// passed as a placeholder.
+func (d dialect) LikeExpr(column string) string {
+	return column + " " + d.Like + " ?" + d.LikeEscape
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likeContains returns a pattern for LIKE which matches all strings
+// containing s.
+func likeContains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// parseDSN returns the dialect and the driver specific data source name for
+// dsn. The scheme of dsn selects the database engine, e.g.
+// sqlite:///var/lib/vmail.db or postgres://user@localhost/vmail, a DSN
+// without a scheme is passed to the MySQL driver as is.
+func parseDSN(dsn string) (dialect, string) {
+	for _, prefix := range []string{"sqlite://", "sqlite3://"} {
+		if strings.HasPrefix(dsn, prefix) {
+			return dialectSQLite, sqliteSource(strings.TrimPrefix(dsn, prefix))
+		}
+	}
+
+	for _, prefix := range []string{"postgres://", "postgresql://"} {
+		if strings.HasPrefix(dsn, prefix) {
+			return dialectPostgres, dsn
+		}
+	}
+
+	return dialectMySQL, strings.TrimPrefix(dsn, "mysql://")
+}
+
+// sqliteSource enables foreign key checks for the SQLite database file.
+func sqliteSource(file string) string {
+	if strings.Contains(file, "_foreign_keys=") {
+		return file
+	}
+
+	if strings.Contains(file, "?") {
+		return file + "&_foreign_keys=1"
+	}
+
+	return file + "?_foreign_keys=1"
+}
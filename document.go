@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// documentVersion is the version of the document format written by this
+// program.
+const documentVersion = 1
+
+// Document describes domains, mailboxes, aliases, alias domains and TLS
+// policies, it is written by 'vmail export' and read by 'vmail apply'.
+type Document struct {
+	Version      int                   `json:"version" yaml:"version" toml:"version"`
+	Domains      []DocumentDomain      `json:"domains" yaml:"domains" toml:"domains"`
+	AliasDomains []DocumentAliasDomain `json:"alias_domains,omitempty" yaml:"alias_domains,omitempty" toml:"alias_domains,omitempty"`
+	TLSPolicies  []DocumentTLSPolicy   `json:"tls_policies,omitempty" yaml:"tls_policies,omitempty" toml:"tls_policies,omitempty"`
+}
+
+// DocumentDomain is a domain with all mailboxes and aliases. When Enabled,
+// SendOnly or Limits are not set, they are left as they are (new domains are
+// enabled, receive mail and have no limits).
+type DocumentDomain struct {
+	Name      string            `json:"name" yaml:"name" toml:"name"`
+	Enabled   *bool             `json:"enabled,omitempty" yaml:"enabled,omitempty" toml:"enabled,omitempty"`
+	SendOnly  *bool             `json:"send_only,omitempty" yaml:"send_only,omitempty" toml:"send_only,omitempty"`
+	Limits    *DocumentLimits   `json:"limits,omitempty" yaml:"limits,omitempty" toml:"limits,omitempty"`
+	Mailboxes []DocumentMailbox `json:"mailboxes,omitempty" yaml:"mailboxes,omitempty" toml:"mailboxes,omitempty"`
+	Aliases   []DocumentAlias   `json:"aliases,omitempty" yaml:"aliases,omitempty" toml:"aliases,omitempty"`
+}
+
+// DocumentLimits are the limits for a domain, zero means unlimited.
+type DocumentLimits struct {
+	MaxMailboxes int `json:"max_mailboxes,omitempty" yaml:"max_mailboxes,omitempty" toml:"max_mailboxes,omitempty"`
+	MaxAliases   int `json:"max_aliases,omitempty" yaml:"max_aliases,omitempty" toml:"max_aliases,omitempty"`
+	MaxQuota     int `json:"max_quota,omitempty" yaml:"max_quota,omitempty" toml:"max_quota,omitempty"`
+	DefaultQuota int `json:"default_quota,omitempty" yaml:"default_quota,omitempty" toml:"default_quota,omitempty"`
+}
+
+// DomainLimits returns the limits for the database.
+func (l DocumentLimits) DomainLimits() DomainLimits {
+	return DomainLimits{
+		MaxAccounts:  l.MaxMailboxes,
+		MaxAliases:   l.MaxAliases,
+		MaxQuota:     l.MaxQuota,
+		DefaultQuota: l.DefaultQuota,
+	}
+}
+
+// DocumentMailbox is a mailbox. Fields which are not set keep their current
+// value for existing mailboxes, new mailboxes are enabled, receive mail and
+// get the default quota of the domain.
+type DocumentMailbox struct {
+	Address      string `json:"address" yaml:"address" toml:"address"`
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty" toml:"password_hash,omitempty"`
+	Quota        *int   `json:"quota,omitempty" yaml:"quota,omitempty" toml:"quota,omitempty"`
+	SendOnly     *bool  `json:"send_only,omitempty" yaml:"send_only,omitempty" toml:"send_only,omitempty"`
+	Enabled      *bool  `json:"enabled,omitempty" yaml:"enabled,omitempty" toml:"enabled,omitempty"`
+}
+
+// DocumentAlias forwards mail for the source address to all destinations.
+// The source "*@domain" is the catch-all alias for the domain. When Enabled
+// is not set, the alias is enabled.
+type DocumentAlias struct {
+	Source       string   `json:"source" yaml:"source" toml:"source"`
+	Destinations []string `json:"destinations" yaml:"destinations" toml:"destinations"`
+	Blacklisted  bool     `json:"blacklisted" yaml:"blacklisted" toml:"blacklisted"`
+	Enabled      *bool    `json:"enabled,omitempty" yaml:"enabled,omitempty" toml:"enabled,omitempty"`
+}
+
+// IsEnabled returns whether the alias is enabled.
+func (a DocumentAlias) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
+}
+
+// DocumentAliasDomain redirects all addresses in Domain to Target. Both
+// domains must be listed in the document.
+type DocumentAliasDomain struct {
+	Domain string `json:"domain" yaml:"domain" toml:"domain"`
+	Target string `json:"target" yaml:"target" toml:"target"`
+}
+
+// DocumentTLSPolicy is the TLS policy for a destination domain.
+type DocumentTLSPolicy struct {
+	Domain string `json:"domain" yaml:"domain" toml:"domain"`
+	Policy string `json:"policy" yaml:"policy" toml:"policy"`
+	Params string `json:"params,omitempty" yaml:"params,omitempty" toml:"params,omitempty"`
+}
+
+// documentFormat returns the format of a file based on the extension,
+// YAML is used for everything else (including JSON, which is valid YAML).
+func documentFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	}
+
+	return "yaml"
+}
+
+// readDocument loads a document from a file, "-" reads from stdin. If format
+// is empty, it is selected by the file extension.
+func readDocument(filename, format string) (Document, error) {
+	var (
+		buf []byte
+		err error
+	)
+
+	if filename == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return Document{}, err
+	}
+
+	if format == "" {
+		format = documentFormat(filename)
+	}
+
+	return decodeDocument(buf, format)
+}
+
+// decodeDocument parses buf in the given format (json, yaml or toml) and
+// checks the document version.
+func decodeDocument(buf []byte, format string) (Document, error) {
+	var (
+		doc Document
+		err error
+	)
+
+	switch format {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&doc)
+	case "toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(buf), &doc)
+		if err == nil && len(md.Undecoded()) > 0 {
+			var keys []string
+			for _, key := range md.Undecoded() {
+				keys = append(keys, key.String())
+			}
+			err = fmt.Errorf("unknown keys %v", strings.Join(keys, ", "))
+		}
+	case "yaml":
+		err = yaml.UnmarshalStrict(buf, &doc)
+	default:
+		return Document{}, fmt.Errorf("unknown document format %q", format)
+	}
+	if err != nil {
+		return Document{}, fmt.Errorf("parsing %v failed: %v", format, err)
+	}
+
+	if doc.Version != documentVersion {
+		return Document{}, fmt.Errorf("unsupported document version %d (need %d)", doc.Version, documentVersion)
+	}
+
+	return doc, nil
+}
+
+// encodeDocument returns doc in the given format (json, yaml or toml).
+func encodeDocument(doc Document, format string) ([]byte, error) {
+	switch format {
+	case "toml":
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(doc)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		buf, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, '\n'), nil
+	case "yaml":
+		return yaml.Marshal(doc)
+	}
+
+	return nil, fmt.Errorf("unknown document format %q", format)
+}
+
+// Validate checks the document for errors and returns all of them at once.
+func (doc Document) Validate() error {
+	var errs []string
+	report := func(s string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(s, args...))
+	}
+
+	domains := make(map[string]struct{})
+	for _, d := range doc.Domains {
+		if d.Name == "" {
+			report("domain with empty name")
+			continue
+		}
+
+		if _, ok := domains[d.Name]; ok {
+			report("domain %v: listed more than once", d.Name)
+		}
+		domains[d.Name] = struct{}{}
+
+		if l := d.Limits; l != nil {
+			if l.MaxMailboxes < 0 || l.MaxAliases < 0 || l.MaxQuota < 0 || l.DefaultQuota < 0 {
+				report("domain %v: negative limit", d.Name)
+			}
+
+			if l.MaxQuota > 0 && l.DefaultQuota > l.MaxQuota {
+				report("domain %v: default quota is larger than the total quota", d.Name)
+			}
+		}
+
+		mailboxes := make(map[string]struct{})
+		for _, m := range d.Mailboxes {
+			_, domain, err := splitMailAddress(m.Address)
+			if err != nil {
+				report("domain %v: mailbox %v: %v", d.Name, m.Address, err)
+				continue
+			}
+
+			if domain != d.Name {
+				report("domain %v: mailbox %v belongs to a different domain", d.Name, m.Address)
+			}
+
+			if _, ok := mailboxes[m.Address]; ok {
+				report("domain %v: mailbox %v listed more than once", d.Name, m.Address)
+			}
+			mailboxes[m.Address] = struct{}{}
+
+			if m.PasswordHash != "" {
+				err = checkHash(m.PasswordHash)
+				if err != nil {
+					report("domain %v: mailbox %v: %v", d.Name, m.Address, err)
+				}
+			}
+
+			if m.Quota != nil && *m.Quota < 0 {
+				report("domain %v: mailbox %v: negative quota", d.Name, m.Address)
+			}
+		}
+
+		for _, a := range d.Aliases {
+			_, domain, err := parseAliasSource(a.Source)
+			if err != nil {
+				report("domain %v: alias %v: %v", d.Name, a.Source, err)
+				continue
+			}
+
+			if domain != d.Name {
+				report("domain %v: alias %v belongs to a different domain", d.Name, a.Source)
+			}
+
+			if len(a.Destinations) == 0 {
+				report("domain %v: alias %v has no destinations", d.Name, a.Source)
+			}
+
+			for _, dest := range a.Destinations {
+				_, _, err := splitMailAddress(dest)
+				if err != nil {
+					report("domain %v: alias %v: destination %v: %v", d.Name, a.Source, dest, err)
+				}
+			}
+		}
+	}
+
+	aliasDomains := make(map[string]string)
+	for _, ad := range doc.AliasDomains {
+		if ad.Domain == "" || ad.Target == "" {
+			report("alias domain with empty domain or target")
+			continue
+		}
+
+		if _, ok := aliasDomains[ad.Domain]; ok {
+			report("alias domain %v listed more than once", ad.Domain)
+		}
+		aliasDomains[ad.Domain] = ad.Target
+
+		if ad.Domain == ad.Target {
+			report("alias domain %v: target is the domain itself", ad.Domain)
+		}
+
+		for _, name := range []string{ad.Domain, ad.Target} {
+			if _, ok := domains[name]; !ok {
+				report("alias domain %v: domain %v is not listed", ad.Domain, name)
+			}
+		}
+	}
+
+	for _, d := range doc.Domains {
+		if _, ok := aliasDomains[d.Name]; ok && len(d.Mailboxes) > 0 {
+			report("domain %v: alias domain cannot have mailboxes", d.Name)
+		}
+	}
+
+	for alias, target := range aliasDomains {
+		if _, ok := aliasDomains[target]; ok {
+			report("alias domain %v: target %v is an alias domain itself", alias, target)
+		}
+	}
+
+	policies := make(map[string]struct{})
+	for _, p := range doc.TLSPolicies {
+		if p.Domain == "" {
+			report("TLS policy with empty domain")
+			continue
+		}
+
+		if _, ok := policies[p.Domain]; ok {
+			report("TLS policy for %v listed more than once", p.Domain)
+		}
+		policies[p.Domain] = struct{}{}
+
+		err := checkTLSPolicy(p.Policy, p.Params)
+		if err != nil {
+			report("TLS policy for %v: %v", p.Domain, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
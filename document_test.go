@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeDocumentUnknownKeys(t *testing.T) {
+	var tests = []struct {
+		format string
+		valid  string
+		typo   string
+	}{
+		{
+			"json",
+			`{"version": 1, "domains": [{"name": "example.com"}]}`,
+			`{"version": 1, "domains": [{"name": "example.com", "mailboxs": []}]}`,
+		},
+		{
+			"yaml",
+			"version: 1\ndomains:\n  - name: example.com\n",
+			"version: 1\ndomains:\n  - name: example.com\n    mailboxs: []\n",
+		},
+		{
+			"toml",
+			"version = 1\n[[domains]]\nname = \"example.com\"\n",
+			"version = 1\n[[domains]]\nname = \"example.com\"\nenabeld = false\n",
+		},
+	}
+
+	for _, test := range tests {
+		doc, err := decodeDocument([]byte(test.valid), test.format)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.format, err)
+		} else if len(doc.Domains) != 1 || doc.Domains[0].Name != "example.com" {
+			t.Errorf("%v: wrong document %+v", test.format, doc)
+		}
+
+		_, err = decodeDocument([]byte(test.typo), test.format)
+		if err == nil {
+			t.Errorf("%v: unknown key was not reported", test.format)
+		}
+	}
+}
+
+func TestEncodeDocument(t *testing.T) {
+	quota, enabled := 1000, false
+	doc := Document{
+		Version: documentVersion,
+		Domains: []DocumentDomain{
+			{
+				Name: "example.com",
+				Mailboxes: []DocumentMailbox{
+					{Address: "admin@example.com", PasswordHash: testPasswordHash, Quota: &quota},
+					{Address: "former@example.com", Enabled: &enabled},
+				},
+				Aliases: []DocumentAlias{
+					{Source: "*@example.com", Destinations: []string{"admin@example.com"}},
+				},
+			},
+		},
+		TLSPolicies: []DocumentTLSPolicy{
+			{Domain: "example.org", Policy: "secure", Params: "match=.example.org"},
+		},
+	}
+
+	for _, format := range []string{"json", "yaml", "toml"} {
+		buf, err := encodeDocument(doc, format)
+		if err != nil {
+			t.Errorf("%v: encoding failed: %v", format, err)
+			continue
+		}
+
+		decoded, err := decodeDocument(buf, format)
+		if err != nil {
+			t.Errorf("%v: decoding failed: %v\n%s", format, err, buf)
+			continue
+		}
+
+		if !reflect.DeepEqual(doc, decoded) {
+			t.Errorf("%v: document changed, want:\n  %+v\ngot:\n  %+v", format, doc, decoded)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Defaults for --generate-password and --generate-passphrase.
+const (
+	generatedPasswordLength  = 20
+	generatedPassphraseWords = 6
+)
+
+// passwordAlphabet contains the characters used for generated passwords.
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:!#%+="
+
+// effWordlist is the large wordlist for passphrases published by the EFF,
+// one word per line after the dice rolls.
+//
+//go:embed eff_large_wordlist.txt
+var effWordlist string
+
+// wordlist returns the words from the EFF wordlist.
+func wordlist() []string {
+	var words []string
+	for _, line := range strings.Split(effWordlist, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		words = append(words, fields[1])
+	}
+
+	return words
+}
+
+// randomIndex returns a uniformly distributed random number in [0, n).
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(i.Int64()), nil
+}
+
+// generatePassword returns a random password of the given length.
+func generatePassword(length int) (string, error) {
+	if length <= 0 {
+		return "", errors.New("length of the password must be positive")
+	}
+
+	pw := make([]byte, length)
+	for i := range pw {
+		j, err := randomIndex(len(passwordAlphabet))
+		if err != nil {
+			return "", err
+		}
+		pw[i] = passwordAlphabet[j]
+	}
+
+	return string(pw), nil
+}
+
+// generatePassphrase returns a random passphrase consisting of the given
+// number of capitalized words from the EFF wordlist, separated by dashes.
+func generatePassphrase(words int) (string, error) {
+	if words <= 0 {
+		return "", errors.New("number of words must be positive")
+	}
+
+	list := wordlist()
+
+	phrase := make([]string, words)
+	for i := range phrase {
+		j, err := randomIndex(len(list))
+		if err != nil {
+			return "", err
+		}
+		phrase[i] = strings.ToUpper(list[j][:1]) + list[j][1:]
+	}
+
+	return strings.Join(phrase, "-"), nil
+}
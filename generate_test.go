@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		pw, err := generatePassword(20)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pw) != 20 {
+			t.Fatalf("want 20 characters, got %q", pw)
+		}
+
+		for _, c := range pw {
+			if !strings.ContainsRune(passwordAlphabet, c) {
+				t.Fatalf("password %q contains invalid character %q", pw, c)
+			}
+		}
+
+		if seen[pw] {
+			t.Fatalf("password %q generated twice", pw)
+		}
+		seen[pw] = true
+	}
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	if n := len(wordlist()); n != 7776 {
+		t.Fatalf("want 7776 words in the list, got %d", n)
+	}
+
+	pw, err := generatePassphrase(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words := strings.Split(pw, "-")
+	if len(words) != 6 {
+		t.Fatalf("want 6 words, got %q", pw)
+	}
+}
@@ -1,20 +1,23 @@
 module github.com/fd0/vmail
 
-go 1.16
+go 1.17
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/Go-SQL-Driver/MySQL v1.4.1
 	github.com/fatih/color v1.7.0
 	github.com/go-sql-driver/mysql v1.4.1 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-colorable v0.1.1 // indirect
 	github.com/mattn/go-isatty v0.0.6 // indirect
-	github.com/mattn/go-sqlite3 v1.10.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/ncw/pwhash v0.0.0-20160129162812-b2a8830c6a99
 	github.com/spf13/cobra v0.0.3
-	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/spf13/pflag v1.0.3
 	golang.org/x/crypto v0.0.0-20190228161510-8dd112bcdc25
 	golang.org/x/sys v0.0.0-20190308023053-584f3b12f43e // indirect
 	google.golang.org/appengine v1.4.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ncw/pwhash/md5_crypt"
+	"github.com/ncw/pwhash/sha256_crypt"
+	"github.com/ncw/pwhash/sha512_crypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters for new password hashes.
+const (
+	hashRounds  = sha512_crypt.RoundsDefault * 10
+	hashSaltLen = 20
+
+	bcryptCost = 12
+
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// verifier checks passwords against hashes in one of the schemes Dovecot
+// supports. All hashes are passed without the "{SCHEME}" prefix.
+type verifier interface {
+	// Verify returns true if pw matches the hash.
+	Verify(pw, hash string) (bool, error)
+
+	// Params returns the cost parameters of the hash.
+	Params(hash string) (hashParams, error)
+}
+
+// hasher creates password hashes in one of the schemes Dovecot supports.
+type hasher interface {
+	verifier
+
+	// Hash returns the hash for pw, without the "{SCHEME}" prefix.
+	Hash(pw string) (string, error)
+
+	// Check returns an error if hash (without the prefix) is malformed.
+	Check(hash string) error
+}
+
+// hashParams describes the cost of computing a hash. Rounds is the number of
+// rounds for SHA256-CRYPT and SHA512-CRYPT, the (logarithmic) cost for
+// BLF-CRYPT and the number of passes for ARGON2ID. Memory (in KiB) and
+// Threads are only used by ARGON2ID.
+type hashParams struct {
+	Rounds  int
+	Memory  int
+	Threads int
+}
+
+func (p hashParams) String() string {
+	switch {
+	case p.Memory > 0:
+		return fmt.Sprintf("rounds=%d, memory=%dKiB, threads=%d", p.Rounds, p.Memory, p.Threads)
+	case p.Rounds > 0:
+		return fmt.Sprintf("rounds=%d", p.Rounds)
+	}
+	return "none"
+}
+
+// hashers contains all supported schemes by the name Dovecot uses.
+var hashers = map[string]hasher{
+	"SHA512-CRYPT": shaCrypt{
+		prefix: sha512_crypt.MagicPrefix,
+		crypt:  sha512_crypt.Crypt,
+		salt:   sha512_crypt.GenerateSalt,
+		format: regexp.MustCompile(`^\$6\$(rounds=[0-9]+\$)?[./0-9A-Za-z]{1,16}\$[./0-9A-Za-z]{86}$`),
+	},
+	"SHA256-CRYPT": shaCrypt{
+		prefix: sha256_crypt.MagicPrefix,
+		crypt:  sha256_crypt.Crypt,
+		salt:   sha256_crypt.GenerateSalt,
+		format: regexp.MustCompile(`^\$5\$(rounds=[0-9]+\$)?[./0-9A-Za-z]{1,16}\$[./0-9A-Za-z]{43}$`),
+	},
+	"BLF-CRYPT": blfCrypt{},
+	"ARGON2ID":  argon2id{},
+}
+
+// legacyVerifiers contains schemes which are only supported for checking
+// existing passwords, new passwords are never hashed with them.
+var legacyVerifiers = map[string]verifier{
+	"PLAIN":     plain{},
+	"PLAIN-MD5": plainMD5{},
+	"MD5-CRYPT": md5Crypt{},
+}
+
+// minHashParams are the parameters new hashes are created with, hashes with
+// a lower cost are reported as weak.
+var minHashParams = map[string]hashParams{
+	"SHA512-CRYPT": {Rounds: hashRounds},
+	"SHA256-CRYPT": {Rounds: hashRounds},
+	"BLF-CRYPT":    {Rounds: bcryptCost},
+	"ARGON2ID":     {Rounds: argon2Time, Memory: argon2Memory},
+}
+
+// below returns true if the cost of p is lower than min.
+func (p hashParams) below(min hashParams) bool {
+	return p.Rounds < min.Rounds || p.Memory < min.Memory
+}
+
+// defaultHashScheme is used for new passwords unless the scheme is selected
+// with --scheme, it can be set with the environment variable
+// VMAIL_HASH_SCHEME.
+var defaultHashScheme = getenv("VMAIL_HASH_SCHEME", "SHA512-CRYPT")
+
+// getenv returns the value of the environment variable name, or def if it is
+// unset or empty.
+func getenv(name, def string) string {
+	if s := os.Getenv(name); s != "" {
+		return s
+	}
+	return def
+}
+
+// hashSchemes returns the names of all supported schemes.
+func hashSchemes() []string {
+	var names []string
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findHasher returns the hasher for the scheme.
+func findHasher(scheme string) (hasher, error) {
+	h, ok := hashers[strings.ToUpper(scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unknown password hash scheme %q, supported are: %v",
+			scheme, strings.Join(hashSchemes(), ", "))
+	}
+
+	return h, nil
+}
+
+// hashPassword returns the hash of pw in the scheme, including the prefix
+// ("{SHA512-CRYPT}$6$...").
+func hashPassword(scheme, pw string) (string, error) {
+	h, err := findHasher(scheme)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := h.Hash(pw)
+	if err != nil {
+		return "", err
+	}
+
+	return "{" + strings.ToUpper(scheme) + "}" + hash, nil
+}
+
+// splitHash returns the scheme and the hash without the "{SCHEME}" prefix.
+func splitHash(hash string) (scheme, value string, err error) {
+	end := strings.Index(hash, "}")
+	if !strings.HasPrefix(hash, "{") || end < 0 {
+		return "", "", errors.New("hash is invalid (does not start with '{SCHEME}')")
+	}
+
+	return hash[1:end], hash[end+1:], nil
+}
+
+// checkHash returns an error unless hash uses one of the supported schemes
+// and is well-formed.
+func checkHash(hash string) error {
+	scheme, value, err := splitHash(hash)
+	if err != nil {
+		return err
+	}
+
+	h, ok := hashers[scheme]
+	if !ok {
+		return fmt.Errorf("hash is invalid (unsupported scheme %q, supported are: %v)",
+			scheme, strings.Join(hashSchemes(), ", "))
+	}
+
+	err = h.Check(value)
+	if err != nil {
+		return fmt.Errorf("hash is invalid (%v: %v)", scheme, err)
+	}
+
+	return nil
+}
+
+// findVerifier splits hash and returns the scheme, the hash without the
+// prefix and the verifier for the scheme.
+func findVerifier(hash string) (scheme, value string, v verifier, err error) {
+	scheme, value, err = splitHash(hash)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if h, ok := hashers[scheme]; ok {
+		return scheme, value, h, nil
+	}
+
+	if v, ok := legacyVerifiers[scheme]; ok {
+		return scheme, value, v, nil
+	}
+
+	return scheme, value, nil, fmt.Errorf("unsupported password hash scheme %q", scheme)
+}
+
+// constantTimeEqual compares two strings without leaking timing information.
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Results of auditing a password hash.
+const (
+	hashOK      = "ok"
+	hashWeak    = "weak"
+	hashLegacy  = "legacy"
+	hashInvalid = "invalid"
+)
+
+// hashAudit describes the scheme and the cost of a password hash.
+type hashAudit struct {
+	Scheme string
+	Params hashParams
+	Status string
+	Reason string
+}
+
+// auditHash classifies the hash: Hashes in a scheme new passwords can be
+// created with are "ok" or "weak" (if the cost is below the one used for new
+// hashes), hashes in other known schemes are "legacy", all others are
+// "invalid".
+func auditHash(hash string) hashAudit {
+	scheme, value, v, err := findVerifier(hash)
+	if err != nil {
+		return hashAudit{Scheme: scheme, Status: hashInvalid, Reason: err.Error()}
+	}
+
+	res := hashAudit{Scheme: scheme}
+
+	res.Params, err = v.Params(value)
+	if err != nil {
+		res.Status, res.Reason = hashInvalid, err.Error()
+		return res
+	}
+
+	min, ok := minHashParams[scheme]
+	switch {
+	case !ok:
+		res.Status, res.Reason = hashLegacy, "scheme is not used for new passwords"
+	case res.Params.below(min):
+		res.Status, res.Reason = hashWeak, fmt.Sprintf("cost below %v", min)
+	default:
+		res.Status = hashOK
+	}
+
+	return res
+}
+
+// shaCrypt implements the SHA256-CRYPT and SHA512-CRYPT schemes.
+type shaCrypt struct {
+	prefix string
+	crypt  func(key, salt string) string
+	salt   func(length, rounds int) string
+	format *regexp.Regexp
+}
+
+func (s shaCrypt) Hash(pw string) (string, error) {
+	return s.crypt(pw, s.salt(hashSaltLen, hashRounds)), nil
+}
+
+func (s shaCrypt) Check(hash string) error {
+	if !s.format.MatchString(hash) {
+		return fmt.Errorf("does not match '%vrounds=N$salt$hash'", s.prefix)
+	}
+	return nil
+}
+
+func (s shaCrypt) Verify(pw, hash string) (bool, error) {
+	err := s.Check(hash)
+	if err != nil {
+		return false, err
+	}
+
+	return constantTimeEqual(s.crypt(pw, hash), hash), nil
+}
+
+func (s shaCrypt) Params(hash string) (hashParams, error) {
+	err := s.Check(hash)
+	if err != nil {
+		return hashParams{}, err
+	}
+
+	// crypt(3) uses 5000 rounds if the hash does not specify the number
+	rounds := sha512_crypt.RoundsDefault
+	field := strings.Split(hash, "$")[2]
+	if strings.HasPrefix(field, "rounds=") {
+		rounds, err = strconv.Atoi(strings.TrimPrefix(field, "rounds="))
+		if err != nil {
+			return hashParams{}, err
+		}
+	}
+
+	return hashParams{Rounds: rounds}, nil
+}
+
+// blfCrypt implements the BLF-CRYPT scheme (bcrypt).
+type blfCrypt struct{}
+
+var blfCryptFormat = regexp.MustCompile(`^\$2[aby]?\$[0-9]{2}\$[./0-9A-Za-z]{53}$`)
+
+func (blfCrypt) Hash(pw string) (string, error) {
+	buf, err := bcrypt.GenerateFromPassword([]byte(pw), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+
+	// the hash is computed correctly for all characters, which is
+	// labelled as $2y$ by Dovecot
+	return "$2y$" + strings.TrimPrefix(string(buf), "$2a$"), nil
+}
+
+func (blfCrypt) Check(hash string) error {
+	if !blfCryptFormat.MatchString(hash) {
+		return errors.New("does not match '$2y$cost$salthash'")
+	}
+	return nil
+}
+
+func (b blfCrypt) Verify(pw, hash string) (bool, error) {
+	err := b.Check(hash)
+	if err != nil {
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (b blfCrypt) Params(hash string) (hashParams, error) {
+	err := b.Check(hash)
+	if err != nil {
+		return hashParams{}, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return hashParams{}, err
+	}
+
+	return hashParams{Rounds: cost}, nil
+}
+
+// argon2id implements the ARGON2ID scheme in the format used by libsodium.
+type argon2id struct{}
+
+var argon2idFormat = regexp.MustCompile(`^\$argon2id\$v=19\$m=[0-9]+,t=[0-9]+,p=[0-9]+\$[A-Za-z0-9+/]+\$[A-Za-z0-9+/]+$`)
+
+func (argon2id) Hash(pw string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(pw), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (argon2id) Check(hash string) error {
+	if !argon2idFormat.MatchString(hash) {
+		return errors.New("does not match '$argon2id$v=19$m=M,t=T,p=P$salt$hash'")
+	}
+	return nil
+}
+
+// parse returns the parameters, the salt and the key of the hash.
+func (a argon2id) parse(hash string) (p hashParams, salt, key []byte, err error) {
+	err = a.Check(hash)
+	if err != nil {
+		return hashParams{}, nil, nil, err
+	}
+
+	fields := strings.Split(hash, "$")
+
+	_, err = fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Rounds, &p.Threads)
+	if err != nil {
+		return hashParams{}, nil, nil, fmt.Errorf("invalid parameters %q: %v", fields[3], err)
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return hashParams{}, nil, nil, fmt.Errorf("invalid salt: %v", err)
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil {
+		return hashParams{}, nil, nil, fmt.Errorf("invalid hash: %v", err)
+	}
+
+	return p, salt, key, nil
+}
+
+func (a argon2id) Verify(pw, hash string) (bool, error) {
+	p, salt, key, err := a.parse(hash)
+	if err != nil {
+		return false, err
+	}
+
+	if p.Rounds < 1 || p.Threads < 1 || p.Threads > 255 {
+		return false, fmt.Errorf("invalid parameters %v", p)
+	}
+
+	other := argon2.IDKey([]byte(pw), salt, uint32(p.Rounds), uint32(p.Memory), uint8(p.Threads), uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a argon2id) Params(hash string) (hashParams, error) {
+	p, _, _, err := a.parse(hash)
+	return p, err
+}
+
+// plain implements the PLAIN scheme, the password is stored in cleartext.
+type plain struct{}
+
+func (plain) Verify(pw, hash string) (bool, error) {
+	return constantTimeEqual(pw, hash), nil
+}
+
+func (plain) Params(hash string) (hashParams, error) {
+	return hashParams{}, nil
+}
+
+// plainMD5 implements the PLAIN-MD5 scheme, the hex encoded MD5 hash of the
+// password without salt.
+type plainMD5 struct{}
+
+func (plainMD5) Verify(pw, hash string) (bool, error) {
+	if len(hash) != 2*md5.Size {
+		return false, errors.New("hash is not a hex encoded MD5 hash")
+	}
+
+	sum := md5.Sum([]byte(pw))
+	return constantTimeEqual(hex.EncodeToString(sum[:]), strings.ToLower(hash)), nil
+}
+
+func (plainMD5) Params(hash string) (hashParams, error) {
+	return hashParams{Rounds: 1}, nil
+}
+
+// md5Crypt implements the MD5-CRYPT scheme.
+type md5Crypt struct{}
+
+// md5CryptRounds is the fixed number of rounds used by MD5-CRYPT.
+const md5CryptRounds = 1000
+
+func (md5Crypt) Verify(pw, hash string) (bool, error) {
+	if !strings.HasPrefix(hash, md5_crypt.MagicPrefix) {
+		return false, fmt.Errorf("hash does not start with %v", md5_crypt.MagicPrefix)
+	}
+
+	return constantTimeEqual(md5_crypt.Crypt(pw, hash), hash), nil
+}
+
+func (md5Crypt) Params(hash string) (hashParams, error) {
+	return hashParams{Rounds: md5CryptRounds}, nil
+}
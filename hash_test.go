@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	for _, scheme := range hashSchemes() {
+		t.Run(scheme, func(t *testing.T) {
+			hash, err := hashPassword(scheme, "correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(hash, "{"+scheme+"}") {
+				t.Fatalf("hash %q does not start with the scheme", hash)
+			}
+
+			err = checkHash(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckHash(t *testing.T) {
+	var tests = []struct {
+		hash  string
+		valid bool
+	}{
+		{"{SHA512-CRYPT}$6$rounds=50000$ulmVUEocA1Bat02X$QU5qy9UbKxw5CY3kTSX4qDi0H4CIjCZ7WzddrdmGNpLsmkThITCZZXDPHDAAVoHRdDhPz.2mTZXg66mi5SAmC0", true},
+		{"{SHA512-CRYPT}$6$rounds=50000$salt$hash", false},
+		{"{SHA256-CRYPT}$5$rounds=5000$usesomesillystri$KqJWpanXZHKq2BOB43TSaYhEWsQ1Lr5QNyPCDH/Tp.6", true},
+		{"{SHA256-CRYPT}$6$rounds=5000$usesomesillystri$KqJWpanXZHKq2BOB43TSaYhEWsQ1Lr5QNyPCDH/Tp.6", false},
+		{"{BLF-CRYPT}$2y$05$bvIG6Nmid91Mu9RcmmWZfO5HJIMCT8riNW0hEp8f6/FuA2/mHZFpe", true},
+		{"{BLF-CRYPT}$2y$05$bvIG6Nmid91Mu9RcmmWZfO5HJIMCT8riNW0hEp8f6", false},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=3,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", true},
+		{"{ARGON2ID}$argon2i$v=19$m=65536,t=3,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", false},
+		{"{PLAIN}secret", false},
+		{"$6$rounds=50000$salt$hash", false},
+	}
+
+	for _, test := range tests {
+		err := checkHash(test.hash)
+		if test.valid && err != nil {
+			t.Errorf("hash %q: unexpected error %v", test.hash, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("hash %q: expected error", test.hash)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	var hashes = []string{"{PLAIN}correct horse battery staple"}
+	for _, scheme := range hashSchemes() {
+		hash, err := hashPassword(scheme, "correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	for _, hash := range hashes {
+		scheme, value, v, err := findVerifier(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, err := v.Verify("correct horse battery staple", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("%v: password does not match", scheme)
+		}
+
+		ok, err = v.Verify("wrong", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("%v: wrong password matches", scheme)
+		}
+	}
+}
+
+func TestHashParams(t *testing.T) {
+	var tests = []struct {
+		hash   string
+		params hashParams
+	}{
+		{"{SHA512-CRYPT}$6$rounds=50000$ulmVUEocA1Bat02X$QU5qy9UbKxw5CY3kTSX4qDi0H4CIjCZ7WzddrdmGNpLsmkThITCZZXDPHDAAVoHRdDhPz.2mTZXg66mi5SAmC0", hashParams{Rounds: 50000}},
+		{"{SHA256-CRYPT}$5$usesomesillystri$KqJWpanXZHKq2BOB43TSaYhEWsQ1Lr5QNyPCDH/Tp.6", hashParams{Rounds: 5000}},
+		{"{BLF-CRYPT}$2y$05$bvIG6Nmid91Mu9RcmmWZfO5HJIMCT8riNW0hEp8f6/FuA2/mHZFpe", hashParams{Rounds: 5}},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=3,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", hashParams{Rounds: 3, Memory: 65536, Threads: 1}},
+	}
+
+	for _, test := range tests {
+		_, value, v, err := findVerifier(test.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params, err := v.Params(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if params != test.params {
+			t.Errorf("hash %q: want params %v, got %v", test.hash, test.params, params)
+		}
+	}
+}
+
+func TestAuditHash(t *testing.T) {
+	var tests = []struct {
+		hash   string
+		status string
+	}{
+		{"{SHA512-CRYPT}$6$rounds=50000$ulmVUEocA1Bat02X$QU5qy9UbKxw5CY3kTSX4qDi0H4CIjCZ7WzddrdmGNpLsmkThITCZZXDPHDAAVoHRdDhPz.2mTZXg66mi5SAmC0", hashOK},
+		{"{SHA512-CRYPT}$6$rounds=5000$ulmVUEocA1Bat02X$QU5qy9UbKxw5CY3kTSX4qDi0H4CIjCZ7WzddrdmGNpLsmkThITCZZXDPHDAAVoHRdDhPz.2mTZXg66mi5SAmC0", hashWeak},
+		{"{SHA256-CRYPT}$5$usesomesillystri$KqJWpanXZHKq2BOB43TSaYhEWsQ1Lr5QNyPCDH/Tp.6", hashWeak},
+		{"{BLF-CRYPT}$2y$05$bvIG6Nmid91Mu9RcmmWZfO5HJIMCT8riNW0hEp8f6/FuA2/mHZFpe", hashWeak},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=3,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", hashOK},
+		{"{ARGON2ID}$argon2id$v=19$m=4096,t=3,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", hashWeak},
+		{"{PLAIN-MD5}5ebe2294ecd0e0f08eab7690d2a6ee69", hashLegacy},
+		{"{PLAIN}secret", hashLegacy},
+		{"{SHA512-CRYPT}$6$rounds=50000$salt$hash", hashInvalid},
+		{"{CRAM-MD5}abc", hashInvalid},
+		{"secret", hashInvalid},
+	}
+
+	for _, test := range tests {
+		res := auditHash(test.hash)
+		if res.Status != test.status {
+			t.Errorf("hash %q: want status %v, got %v (%v)", test.hash, test.status, res.Status, res.Reason)
+		}
+	}
+}
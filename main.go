@@ -6,11 +6,22 @@ import (
 	"os/user"
 
 	_ "github.com/Go-SQL-Driver/MySQL"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/spf13/cobra"
 )
 
 var opts struct {
-	Database string
+	Profile        string
+	Database       string
+	Output         string
+	IncludeSecrets bool
+	AuditLogFile   string
+	DryRun         bool
+	Policy         passwordPolicy
+
+	// databasePasswordFile is set from the profile
+	databasePasswordFile string
 
 	db *DB
 }
@@ -25,28 +36,140 @@ func init() {
 		}
 	}
 
-	root.Flags().StringVar(&opts.Database, "database", defaultDatabase, "connect to this database")
+	root.PersistentFlags().StringVar(&opts.Profile, "profile", os.Getenv("VMAIL_PROFILE"), "use the settings from the profile `name` in the config file")
+	root.PersistentFlags().StringVar(&opts.Database, "database", defaultDatabase, "connect to this database")
+	root.PersistentFlags().StringVarP(&opts.Output, "output", "o", "table", "print listings as `format` (table, json, yaml, csv or tsv)")
+	root.PersistentFlags().BoolVar(&opts.IncludeSecrets, "include-secrets", false, "include password hashes in json, yaml, csv and tsv output")
+	root.PersistentFlags().BoolVar(&opts.DryRun, "dry-run", false, "only print the changes, do not apply them")
+	root.PersistentFlags().StringVar(&opts.AuditLogFile, "audit-log-file", "", "record changes in `file` (JSON lines) instead of the audit_log table")
+
+	p := defaultPasswordPolicy
+	root.PersistentFlags().IntVar(&opts.Policy.MinLength, "password-min-length", p.MinLength, "require new passwords to have at least `n` characters")
+	root.PersistentFlags().IntVar(&opts.Policy.MaxLength, "password-max-length", p.MaxLength, "allow at most `n` characters for new passwords (0 for no limit)")
+	root.PersistentFlags().IntVar(&opts.Policy.MinClasses, "password-min-classes", p.MinClasses, "require `n` character classes (lower, upper, digits, others) in new passwords")
+	root.PersistentFlags().BoolVar(&opts.Policy.AllowAddress, "password-allow-address", p.AllowAddress, "allow new passwords which contain the local part or domain of the mailbox")
+	root.PersistentFlags().StringVar(&opts.Policy.BreachedList, "breached-passwords", p.BreachedList, "reject new passwords listed in `file` or directory (SHA1 hashes)")
 }
 
 var root = cobra.Command{
 	SilenceUsage:  true,
 	SilenceErrors: true,
 	Use:           "vmail [flags] command",
-	PersistentPreRunE: func(_ *cobra.Command, _ []string) (err error) {
-		opts.db, err = ConnectDB("mysql", opts.Database)
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+		err := setup(cmd)
+		if err != nil {
+			return err
+		}
+
+		err = opts.db.CheckSchema()
 		if err != nil {
 			return err
 		}
-		return nil
+
+		if opts.DryRun {
+			opts.db, err = opts.db.BeginDryRun()
+		}
+		return err
 	},
 	PersistentPostRunE: func(_ *cobra.Command, _ []string) error {
+		if opts.DryRun {
+			entries, err := opts.db.Rollback()
+			if err != nil {
+				return err
+			}
+			reportDryRun(entries)
+		}
+
 		return opts.db.Close()
 	},
 }
 
+// setup applies the settings from the profile to all flags of cmd which were
+// not passed on the command line and connects to the database.
+func setup(cmd *cobra.Command) error {
+	cfg, err := loadConfig(configFiles())
+	if err != nil {
+		return err
+	}
+
+	profile, err := cfg.Profile(opts.Profile)
+	if err != nil {
+		return err
+	}
+
+	err = profile.apply(cmd)
+	if err != nil {
+		return err
+	}
+	opts.databasePasswordFile = profile.DatabasePasswordFile
+
+	err = checkOutputFormat(opts.Output)
+	if err != nil {
+		return err
+	}
+
+	err = connect()
+	if err != nil {
+		return err
+	}
+
+	opts.db.SetAuditFile(opts.AuditLogFile)
+	return nil
+}
+
+// connect opens the database connection.
+func connect() (err error) {
+	dsn := opts.Database
+	if opts.databasePasswordFile != "" {
+		password, err := readSecret(opts.databasePasswordFile)
+		if err != nil {
+			return fmt.Errorf("reading database password failed: %v", err)
+		}
+
+		dsn, err = dsnWithPassword(dsn, password)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts.db, err = ConnectDB(dsn)
+	return err
+}
+
+// reportDryRun prints the changes which were rolled back because of
+// --dry-run.
+func reportDryRun(entries []auditEntry) {
+	if len(entries) == 0 {
+		msg("dry run, nothing would be changed")
+		return
+	}
+
+	msg("dry run, %d changes not applied:", len(entries))
+	for _, e := range entries {
+		msg("  %v %v (%v)", e.Action, e.Object, e.Changes())
+	}
+}
+
+// exitError is returned by commands which need to exit with a specific
+// status code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
 func main() {
 	err := root.Execute()
 	if err != nil {
 		warn("error: %v", err)
+
+		code := 1
+		if e, ok := err.(exitError); ok {
+			code = e.code
+		}
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,4 @@
+DROP TABLE tlspolicies;
+DROP TABLE aliases;
+DROP TABLE accounts;
+DROP TABLE domains;
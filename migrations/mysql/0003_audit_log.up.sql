@@ -0,0 +1,12 @@
+CREATE TABLE audit_log (
+    id int unsigned NOT NULL AUTO_INCREMENT,
+    created_at varchar(64) NOT NULL,
+    os_user varchar(255) NOT NULL,
+    command text NOT NULL,
+    action varchar(32) NOT NULL,
+    object varchar(1024) NOT NULL,
+    old_value text,
+    new_value text,
+    PRIMARY KEY (id),
+    KEY (created_at)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE aliases DROP COLUMN suspended;
+ALTER TABLE accounts DROP COLUMN suspended;
+ALTER TABLE domains DROP COLUMN enabled;
@@ -0,0 +1,11 @@
+CREATE TABLE alias_domains (
+    id int unsigned NOT NULL AUTO_INCREMENT,
+    alias_domain varchar(255) NOT NULL,
+    target_domain varchar(255) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY (alias_domain),
+    FOREIGN KEY (alias_domain) REFERENCES domains (domain),
+    FOREIGN KEY (target_domain) REFERENCES domains (domain)
+);
+
+ALTER TABLE aliases ADD COLUMN mirrored boolean NOT NULL DEFAULT '0';
@@ -0,0 +1,4 @@
+ALTER TABLE domains ADD COLUMN max_accounts int unsigned NOT NULL DEFAULT '0';
+ALTER TABLE domains ADD COLUMN max_aliases int unsigned NOT NULL DEFAULT '0';
+ALTER TABLE domains ADD COLUMN max_quota bigint unsigned NOT NULL DEFAULT '0';
+ALTER TABLE domains ADD COLUMN default_quota bigint unsigned NOT NULL DEFAULT '0';
@@ -0,0 +1,2 @@
+ALTER TABLE domains ADD COLUMN sendonly boolean NOT NULL DEFAULT '0';
+ALTER TABLE accounts ADD COLUMN domain_sendonly boolean NOT NULL DEFAULT '0';
@@ -0,0 +1,3 @@
+ALTER TABLE domains ADD COLUMN enabled boolean NOT NULL DEFAULT true;
+ALTER TABLE accounts ADD COLUMN suspended boolean NOT NULL DEFAULT false;
+ALTER TABLE aliases ADD COLUMN suspended boolean NOT NULL DEFAULT false;
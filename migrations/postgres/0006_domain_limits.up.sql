@@ -0,0 +1,4 @@
+ALTER TABLE domains ADD COLUMN max_accounts integer NOT NULL DEFAULT 0 CHECK (max_accounts >= 0);
+ALTER TABLE domains ADD COLUMN max_aliases integer NOT NULL DEFAULT 0 CHECK (max_aliases >= 0);
+ALTER TABLE domains ADD COLUMN max_quota bigint NOT NULL DEFAULT 0 CHECK (max_quota >= 0);
+ALTER TABLE domains ADD COLUMN default_quota bigint NOT NULL DEFAULT 0 CHECK (default_quota >= 0);
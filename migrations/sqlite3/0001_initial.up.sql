@@ -0,0 +1,33 @@
+CREATE TABLE domains (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    domain varchar(255) NOT NULL UNIQUE
+);
+
+CREATE TABLE accounts (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    username varchar(64) NOT NULL,
+    domain varchar(255) NOT NULL REFERENCES domains (domain),
+    password varchar(255) NOT NULL,
+    quota integer DEFAULT 0,
+    enabled boolean DEFAULT 0,
+    sendonly boolean DEFAULT 0,
+    UNIQUE (username, domain)
+);
+
+CREATE TABLE aliases (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    source_username varchar(64),
+    source_domain varchar(255) NOT NULL REFERENCES domains (domain),
+    destination_username varchar(64) NOT NULL,
+    destination_domain varchar(255) NOT NULL,
+    blacklisted boolean DEFAULT 0,
+    enabled boolean DEFAULT 0,
+    UNIQUE (source_username, source_domain, destination_username, destination_domain)
+);
+
+CREATE TABLE tlspolicies (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    domain varchar(255) NOT NULL UNIQUE,
+    policy varchar(16) NOT NULL CHECK (policy IN ('none', 'may', 'encrypt', 'dane', 'dane-only', 'fingerprint', 'verify', 'secure')),
+    params varchar(255)
+);
@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN password_reset boolean NOT NULL DEFAULT 0;
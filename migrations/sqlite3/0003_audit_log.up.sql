@@ -0,0 +1,12 @@
+CREATE TABLE audit_log (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at varchar(64) NOT NULL,
+    os_user varchar(255) NOT NULL,
+    command text NOT NULL,
+    action varchar(32) NOT NULL,
+    object varchar(1024) NOT NULL,
+    old_value text,
+    new_value text
+);
+
+CREATE INDEX audit_log_created_at ON audit_log (created_at);
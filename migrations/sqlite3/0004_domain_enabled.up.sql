@@ -0,0 +1,3 @@
+ALTER TABLE domains ADD COLUMN enabled boolean NOT NULL DEFAULT 1;
+ALTER TABLE accounts ADD COLUMN suspended boolean NOT NULL DEFAULT 0;
+ALTER TABLE aliases ADD COLUMN suspended boolean NOT NULL DEFAULT 0;
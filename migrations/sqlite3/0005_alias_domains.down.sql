@@ -0,0 +1,3 @@
+ALTER TABLE aliases DROP COLUMN mirrored;
+
+DROP TABLE alias_domains;
@@ -0,0 +1,7 @@
+CREATE TABLE alias_domains (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    alias_domain varchar(255) NOT NULL UNIQUE REFERENCES domains (domain),
+    target_domain varchar(255) NOT NULL REFERENCES domains (domain)
+);
+
+ALTER TABLE aliases ADD COLUMN mirrored boolean NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE domains DROP COLUMN default_quota;
+ALTER TABLE domains DROP COLUMN max_quota;
+ALTER TABLE domains DROP COLUMN max_aliases;
+ALTER TABLE domains DROP COLUMN max_accounts;
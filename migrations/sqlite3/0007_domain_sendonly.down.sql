@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN domain_sendonly;
+ALTER TABLE domains DROP COLUMN sendonly;
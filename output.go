@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// outputFormats lists the values accepted by --output.
+var outputFormats = []string{"table", "json", "yaml", "csv", "tsv"}
+
+// checkOutputFormat returns an error if the format is not supported.
+func checkOutputFormat(format string) error {
+	for _, f := range outputFormats {
+		if f == format {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown output format %q, supported are: %v", format, strings.Join(outputFormats, ", "))
+}
+
+// machineOutput returns true if the listing commands should print data in a
+// machine-readable format instead of a table.
+func machineOutput() bool {
+	return opts.Output != "" && opts.Output != "table"
+}
+
+// accountRecord is an Account in machine-readable output.
+type accountRecord struct {
+	Address        string `json:"address" yaml:"address"`
+	Username       string `json:"username" yaml:"username"`
+	Domain         string `json:"domain" yaml:"domain"`
+	PasswordHash   string `json:"password_hash,omitempty" yaml:"password_hash,omitempty" output:"secret"`
+	Quota          int    `json:"quota" yaml:"quota"`
+	Enabled        bool   `json:"enabled" yaml:"enabled"`
+	SendOnly       bool   `json:"send_only" yaml:"send_only"`
+	PasswordReset  bool   `json:"password_reset" yaml:"password_reset"`
+	Suspended      bool   `json:"suspended" yaml:"suspended"`
+	DomainSendOnly bool   `json:"domain_send_only" yaml:"domain_send_only"`
+}
+
+// newAccountRecord returns the record for a, the password hash is only
+// included when --include-secrets is set.
+func newAccountRecord(a Account) accountRecord {
+	r := accountRecord{
+		Address:        a.Username + "@" + a.Domain,
+		Username:       a.Username,
+		Domain:         a.Domain,
+		Quota:          a.Quota,
+		Enabled:        a.Enabled,
+		SendOnly:       a.Sendonly,
+		PasswordReset:  a.PasswordReset,
+		Suspended:      a.Suspended,
+		DomainSendOnly: a.DomainSendonly,
+	}
+
+	if opts.IncludeSecrets {
+		r.PasswordHash = a.Password
+	}
+
+	return r
+}
+
+// aliasRecord is an Alias in machine-readable output, one per destination.
+type aliasRecord struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	CatchAll    bool   `json:"catch_all" yaml:"catch_all"`
+	Blacklisted bool   `json:"blacklisted" yaml:"blacklisted"`
+	Enabled     bool   `json:"enabled" yaml:"enabled"`
+	Suspended   bool   `json:"suspended" yaml:"suspended"`
+}
+
+func newAliasRecord(a Alias) aliasRecord {
+	return aliasRecord{
+		Source:      a.Source(),
+		Destination: a.Destination(),
+		CatchAll:    !a.SourceUsername.Valid,
+		Blacklisted: a.Blacklisted,
+		Enabled:     a.Enabled,
+		Suspended:   a.Suspended,
+	}
+}
+
+// domainRecord is a Domain in machine-readable output.
+type domainRecord struct {
+	Domain       string `json:"domain" yaml:"domain"`
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	SendOnly     bool   `json:"send_only" yaml:"send_only"`
+	MaxMailboxes int    `json:"max_mailboxes" yaml:"max_mailboxes"`
+	MaxAliases   int    `json:"max_aliases" yaml:"max_aliases"`
+	MaxQuota     int    `json:"max_quota" yaml:"max_quota"`
+	DefaultQuota int    `json:"default_quota" yaml:"default_quota"`
+}
+
+func newDomainRecord(d Domain) domainRecord {
+	return domainRecord{
+		Domain:       d.Domain,
+		Enabled:      d.Enabled,
+		SendOnly:     d.Sendonly,
+		MaxMailboxes: d.MaxAccounts,
+		MaxAliases:   d.MaxAliases,
+		MaxQuota:     d.MaxQuota,
+		DefaultQuota: d.DefaultQuota,
+	}
+}
+
+// limitsRecord contains the DomainLimits in machine-readable output.
+type limitsRecord struct {
+	MaxMailboxes int `json:"max_mailboxes" yaml:"max_mailboxes"`
+	MaxAliases   int `json:"max_aliases" yaml:"max_aliases"`
+	MaxQuota     int `json:"max_quota" yaml:"max_quota"`
+	DefaultQuota int `json:"default_quota" yaml:"default_quota"`
+}
+
+func newLimitsRecord(l DomainLimits) limitsRecord {
+	return limitsRecord{
+		MaxMailboxes: l.MaxAccounts,
+		MaxAliases:   l.MaxAliases,
+		MaxQuota:     l.MaxQuota,
+		DefaultQuota: l.DefaultQuota,
+	}
+}
+
+// usageRecord is a DomainUsage in machine-readable output.
+type usageRecord struct {
+	Mailboxes      int `json:"mailboxes" yaml:"mailboxes"`
+	Aliases        int `json:"aliases" yaml:"aliases"`
+	Quota          int `json:"quota" yaml:"quota"`
+	UnlimitedQuota int `json:"mailboxes_without_quota" yaml:"mailboxes_without_quota"`
+}
+
+func newUsageRecord(u DomainUsage) usageRecord {
+	return usageRecord{
+		Mailboxes:      u.Accounts,
+		Aliases:        u.Aliases,
+		Quota:          u.Quota,
+		UnlimitedQuota: u.Unlimited,
+	}
+}
+
+// aliasDomainRecord is an AliasDomain in machine-readable output.
+type aliasDomainRecord struct {
+	Domain string `json:"domain" yaml:"domain"`
+	Target string `json:"target" yaml:"target"`
+}
+
+func newAliasDomainRecord(ad AliasDomain) aliasDomainRecord {
+	return aliasDomainRecord{
+		Domain: ad.AliasDomain,
+		Target: ad.TargetDomain,
+	}
+}
+
+// tlsPolicyRecord is a TLSPolicy in machine-readable output.
+type tlsPolicyRecord struct {
+	Domain string `json:"domain" yaml:"domain"`
+	Policy string `json:"policy" yaml:"policy"`
+	Params string `json:"params" yaml:"params"`
+}
+
+func newTLSPolicyRecord(p TLSPolicy) tlsPolicyRecord {
+	return tlsPolicyRecord{
+		Domain: p.Domain,
+		Policy: p.Policy,
+		Params: p.Params.String,
+	}
+}
+
+// writeOutput writes v in the format selected with --output. For CSV and TSV,
+// v must be a slice of structs, the column names are taken from the json tags
+// of the fields. Columns for fields tagged with `output:"secret"` are only
+// included when --include-secrets is set, the values are left empty by
+// newAccountRecord otherwise.
+func writeOutput(wr io.Writer, v interface{}) error {
+	switch opts.Output {
+	case "json":
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(v)
+	case "yaml":
+		buf, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = wr.Write(buf)
+		return err
+	case "csv":
+		return writeRecords(wr, ',', v)
+	case "tsv":
+		return writeRecords(wr, '\t', v)
+	}
+
+	return checkOutputFormat(opts.Output)
+}
+
+// writeRecords writes the slice of structs v as CSV with the separator sep.
+func writeRecords(wr io.Writer, sep rune, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("output format %v is not supported for %T", opts.Output, v)
+	}
+
+	typ := rv.Type().Elem()
+
+	var (
+		fields []int
+		header []string
+	)
+
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Tag.Get("output") == "secret" && !opts.IncludeSecrets {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		fields = append(fields, i)
+		header = append(header, name)
+	}
+
+	w := csv.NewWriter(wr)
+	w.Comma = sep
+
+	err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	row := make([]string, len(fields))
+	for i := 0; i < rv.Len(); i++ {
+		for j, field := range fields {
+			row[j] = fmt.Sprint(rv.Index(i).Field(field).Interface())
+		}
+
+		err = w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func setOutput(t testing.TB, format string, includeSecrets bool) {
+	prevFormat, prevSecrets := opts.Output, opts.IncludeSecrets
+	opts.Output, opts.IncludeSecrets = format, includeSecrets
+	t.Cleanup(func() {
+		opts.Output, opts.IncludeSecrets = prevFormat, prevSecrets
+	})
+}
+
+var testAccount = Account{
+	Username: "admin",
+	Domain:   "example.com",
+	Password: testPasswordHash,
+	Quota:    1000,
+	Enabled:  true,
+}
+
+func TestWriteOutput(t *testing.T) {
+	var tests = []struct {
+		format  string
+		secrets bool
+		want    string
+	}{
+		{
+			"csv", false,
+			"address,username,domain,quota,enabled,send_only,password_reset,suspended,domain_send_only\n" +
+				"admin@example.com,admin,example.com,1000,true,false,false,false,false\n",
+		},
+		{
+			"tsv", false,
+			"address\tusername\tdomain\tquota\tenabled\tsend_only\tpassword_reset\tsuspended\tdomain_send_only\n" +
+				"admin@example.com\tadmin\texample.com\t1000\ttrue\tfalse\tfalse\tfalse\tfalse\n",
+		},
+		{
+			"csv", true,
+			"address,username,domain,password_hash,quota,enabled,send_only,password_reset,suspended,domain_send_only\n" +
+				"admin@example.com,admin,example.com," + testPasswordHash + ",1000,true,false,false,false,false\n",
+		},
+		{
+			"json", false,
+			`[
+  {
+    "address": "admin@example.com",
+    "username": "admin",
+    "domain": "example.com",
+    "quota": 1000,
+    "enabled": true,
+    "send_only": false,
+    "password_reset": false,
+    "suspended": false,
+    "domain_send_only": false
+  }
+]
+`,
+		},
+		{
+			"yaml", false,
+			`- address: admin@example.com
+  username: admin
+  domain: example.com
+  quota: 1000
+  enabled: true
+  send_only: false
+  password_reset: false
+  suspended: false
+  domain_send_only: false
+`,
+		},
+	}
+
+	for _, test := range tests {
+		setOutput(t, test.format, test.secrets)
+
+		var buf bytes.Buffer
+		err := writeOutput(&buf, []accountRecord{newAccountRecord(testAccount)})
+		if err != nil {
+			t.Errorf("%v: %v", test.format, err)
+			continue
+		}
+
+		if buf.String() != test.want {
+			t.Errorf("%v (secrets %v): wrong output, want:\n%s\ngot:\n%s", test.format, test.secrets, test.want, buf.String())
+		}
+	}
+}
+
+func TestWriteOutputSecrets(t *testing.T) {
+	for _, secrets := range []bool{false, true} {
+		for _, format := range []string{"csv", "tsv", "json", "yaml"} {
+			setOutput(t, format, secrets)
+
+			values := []interface{}{[]accountRecord{newAccountRecord(testAccount)}}
+			if format == "json" || format == "yaml" {
+				values = append(values, domainOutput{
+					Domain:    "example.com",
+					Mailboxes: []accountRecord{newAccountRecord(testAccount)},
+				})
+			}
+
+			for _, v := range values {
+				var buf bytes.Buffer
+				err := writeOutput(&buf, v)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				included := strings.Contains(buf.String(), "password_hash") && strings.Contains(buf.String(), testPasswordHash)
+				if included != secrets {
+					t.Errorf("%v (%T): want password hash included %v, got:\n%s", format, v, secrets, buf.String())
+				}
+			}
+		}
+	}
+}
+
+func TestWriteOutputErrors(t *testing.T) {
+	setOutput(t, "csv", false)
+
+	err := writeOutput(&bytes.Buffer{}, newAccountRecord(testAccount))
+	if err == nil {
+		t.Error("writing a single struct as CSV did not fail")
+	}
+
+	setOutput(t, "xml", false)
+
+	err = writeOutput(&bytes.Buffer{}, []accountRecord{newAccountRecord(testAccount)})
+	if err == nil || !strings.Contains(err.Error(), "unknown output format") {
+		t.Errorf("want error for unknown output format, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// promptPassword prints the prompt and reads a password from the terminal
+// without echoing it.
+func promptPassword(prompt string) (string, error) {
+	fmt.Printf("%v: ", prompt)
+	buf, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Printf("\n")
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func readPassword() (string, error) {
+	pw, err := promptPassword("enter password")
+	if err != nil {
+		return "", err
+	}
+
+	pw2, err := promptPassword("repeat password")
+	if err != nil {
+		return "", err
+	}
+
+	if pw != pw2 {
+		return "", errors.New("passwords do not match")
+	}
+
+	return pw, nil
+}
+
+// readPasswordFrom reads a password from rd, a trailing newline is removed.
+func readPasswordFrom(rd io.Reader) (string, error) {
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return "", err
+	}
+
+	pw := strings.TrimSuffix(strings.TrimSuffix(string(buf), "\n"), "\r")
+	if pw == "" {
+		return "", errors.New("no password read")
+	}
+
+	return pw, nil
+}
+
+// readPasswordFD reads a password from the file descriptor fd. The
+// descriptor belongs to the caller and is not closed, for stdin, stdout and
+// stderr the existing files are used.
+func readPasswordFD(fd int) (string, error) {
+	if fd < 0 {
+		return "", fmt.Errorf("invalid file descriptor %d", fd)
+	}
+
+	var f *os.File
+	switch fd {
+	case 0:
+		f = os.Stdin
+	case 1:
+		f = os.Stdout
+	case 2:
+		f = os.Stderr
+	default:
+		f = os.NewFile(uintptr(fd), "fd "+strconv.Itoa(fd))
+	}
+
+	pw, err := readPasswordFrom(f)
+	if err != nil {
+		return "", fmt.Errorf("reading password from file descriptor %d failed: %v", fd, err)
+	}
+
+	return pw, nil
+}
+
+// passwordInput selects where a password is read from when it is not passed
+// on the command line.
+type passwordInput struct {
+	Stdin bool
+	FD    int
+}
+
+func (in *passwordInput) register(fs *pflag.FlagSet) {
+	fs.BoolVar(&in.Stdin, "password-stdin", false, "read the password from stdin")
+	fs.IntVar(&in.FD, "password-fd", -1, "read the password from the file descriptor `n`")
+}
+
+// set returns true if the password should be read from stdin or a file
+// descriptor.
+func (in *passwordInput) set() bool {
+	return in.Stdin || in.FD >= 0
+}
+
+// read returns the password from stdin or the file descriptor.
+func (in *passwordInput) read() (string, error) {
+	if in.Stdin && in.FD >= 0 {
+		return "", errors.New("--password-stdin and --password-fd are mutually exclusive")
+	}
+
+	if in.Stdin {
+		pw, err := readPasswordFrom(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("reading password from stdin failed: %v", err)
+		}
+		return pw, nil
+	}
+
+	return readPasswordFD(in.FD)
+}
+
+// checkPassword returns an error if pw is not acceptable as a password for
+// the mailbox user@domain according to the password policy.
+func checkPassword(pw, user, domain string) error {
+	return opts.Policy.Check(pw, user, domain)
+}
+
+// maxGenerateTries is the number of generated passwords which are checked
+// against the policy before giving up.
+const maxGenerateTries = 100
+
+// passwordFlags selects the new password for a mailbox, it is used by
+// 'vmail create mailbox' and 'vmail password'.
+type passwordFlags struct {
+	Password        string
+	PasswordHash    string
+	RawPasswordHash bool
+	Scheme          string
+	Input           passwordInput
+
+	GeneratePassword   int
+	GeneratePassphrase int
+	GeneratedFile      string
+}
+
+func (f *passwordFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.Password, "password", "", "use `pwd` as the password")
+	fs.StringVar(&f.PasswordHash, "password-hash", "", "use `hash` as the password (already hashed)")
+	fs.BoolVar(&f.RawPasswordHash, "raw-password-hash", false, "do not check password hash")
+	fs.StringVar(&f.Scheme, "scheme", defaultHashScheme, "hash the password with `scheme` (SHA512-CRYPT, SHA256-CRYPT, BLF-CRYPT, ARGON2ID)")
+	f.Input.register(fs)
+
+	fs.IntVar(&f.GeneratePassword, "generate-password", 0, "generate a random password with `length` characters")
+	fs.Lookup("generate-password").NoOptDefVal = strconv.Itoa(generatedPasswordLength)
+	fs.IntVar(&f.GeneratePassphrase, "generate-passphrase", 0, "generate a random passphrase with `words` words")
+	fs.Lookup("generate-passphrase").NoOptDefVal = strconv.Itoa(generatedPassphraseWords)
+	fs.StringVar(&f.GeneratedFile, "generated-password-file", "", "write the generated password to `file` instead of printing it")
+}
+
+// generate returns a new password which satisfies the policy.
+func (f *passwordFlags) generate(user, domain string) (string, error) {
+	var err error
+	for i := 0; i < maxGenerateTries; i++ {
+		var pw string
+		if f.GeneratePassphrase > 0 {
+			pw, err = generatePassphrase(f.GeneratePassphrase)
+		} else {
+			pw, err = generatePassword(f.GeneratePassword)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		err = checkPassword(pw, user, domain)
+		if _, ok := err.(policyError); ok {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return pw, nil
+	}
+
+	return "", fmt.Errorf("unable to generate a password, %v", err)
+}
+
+// passwordHash returns the hash of the new password for the mailbox
+// user@domain. If the password was generated, it is returned as well.
+func (f *passwordFlags) passwordHash(user, domain string) (pwhash, generated string, err error) {
+	var sources int
+	for _, set := range []bool{f.Password != "", f.PasswordHash != "", f.Input.set(), f.GeneratePassword > 0, f.GeneratePassphrase > 0} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return "", "", errors.New("--password, --password-hash, --password-stdin, --password-fd, --generate-password and --generate-passphrase are mutually exclusive")
+	}
+
+	if f.GeneratedFile != "" && f.GeneratePassword == 0 && f.GeneratePassphrase == 0 {
+		return "", "", errors.New("--generated-password-file needs --generate-password or --generate-passphrase")
+	}
+
+	if f.GeneratedFile != "" {
+		if _, err := os.Lstat(f.GeneratedFile); err == nil {
+			return "", "", fmt.Errorf("file %v for the generated password already exists", f.GeneratedFile)
+		}
+	}
+
+	pwhash = f.PasswordHash
+	if pwhash == "" {
+		var pw = f.Password
+		switch {
+		case f.GeneratePassword > 0 || f.GeneratePassphrase > 0:
+			pw, err = f.generate(user, domain)
+			if err != nil {
+				return "", "", err
+			}
+			generated = pw
+		case f.Input.set():
+			pw, err = f.Input.read()
+			if err != nil {
+				return "", "", err
+			}
+		case pw == "":
+			pw, err = readPassword()
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		if generated == "" {
+			err = checkPassword(pw, user, domain)
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		pwhash, err = hashPassword(f.Scheme, pw)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if !f.RawPasswordHash {
+		err = checkHash(pwhash)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return pwhash, generated, nil
+}
+
+// reveal shows a generated password exactly once, either on stderr or by
+// writing it to the file selected with --generated-password-file (which
+// must not exist yet).
+func (f *passwordFlags) reveal(mailbox, generated string) error {
+	// with --dry-run the password is not stored, so it is not needed
+	if generated == "" || opts.DryRun {
+		return nil
+	}
+
+	if f.GeneratedFile == "" {
+		warn("generated password for %v: %v", mailbox, generated)
+		return nil
+	}
+
+	err := writeNewFile(f.GeneratedFile, []byte(generated+"\n"))
+	if err != nil {
+		// the password is already stored, so it must not get lost
+		warn("generated password for %v: %v", mailbox, generated)
+		return fmt.Errorf("writing password to %v failed: %v", f.GeneratedFile, err)
+	}
+
+	msg("generated password for %v written to %v", mailbox, f.GeneratedFile)
+	return nil
+}
+
+// writeNewFile creates the file, which is only accessible for the current
+// user, and writes buf to it.
+func writeNewFile(filename string, buf []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadPasswordFrom(t *testing.T) {
+	var tests = []struct {
+		input string
+		pw    string
+	}{
+		{"secret", "secret"},
+		{"secret\n", "secret"},
+		{"secret\r\n", "secret"},
+		{" secret with spaces \n", " secret with spaces "},
+		{"secret\n\n", "secret\n"},
+	}
+
+	for _, test := range tests {
+		pw, err := readPasswordFrom(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pw != test.pw {
+			t.Errorf("input %q: want password %q, got %q", test.input, test.pw, pw)
+		}
+	}
+
+	_, err := readPasswordFrom(strings.NewReader("\n"))
+	if err == nil {
+		t.Error("empty password was accepted")
+	}
+}
+
+func TestReadPasswordFDStdin(t *testing.T) {
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	prev := os.Stdin
+	os.Stdin = rd
+	defer func() {
+		os.Stdin = prev
+	}()
+
+	_, err = wr.WriteString("secret\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = wr.Close()
+
+	pw, err := readPasswordFD(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pw != "secret" {
+		t.Errorf("want password %q, got %q", "secret", pw)
+	}
+
+	// stdin must still be usable afterwards
+	_, err = rd.Stat()
+	if err != nil {
+		t.Errorf("stdin was closed: %v", err)
+	}
+}
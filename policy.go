@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// passwordPolicy contains the rules for new passwords.
+type passwordPolicy struct {
+	// MinLength and MaxLength are the number of characters allowed.
+	MinLength int
+	MaxLength int
+
+	// MinClasses is the number of different character classes (lower case,
+	// upper case, digits and others) the password must contain.
+	MinClasses int
+
+	// AllowAddress permits passwords which contain the local part or the
+	// domain of the mailbox.
+	AllowAddress bool
+
+	// BreachedList is a file or a directory with SHA1 hashes of passwords
+	// known to be breached, see breachedPassword.
+	BreachedList string
+}
+
+// defaultPasswordPolicy is used unless the policy is configured.
+var defaultPasswordPolicy = passwordPolicy{
+	MinLength: 8,
+	MaxLength: 128,
+}
+
+// policyError lists all rules of the policy a password violates.
+type policyError []string
+
+func (e policyError) Error() string {
+	return "password does not meet the policy:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// Check returns an error listing all rules pw violates for the mailbox
+// user@domain.
+func (p passwordPolicy) Check(pw, user, domain string) error {
+	var failed policyError
+
+	length := utf8.RuneCountInString(pw)
+	if length < p.MinLength {
+		failed = append(failed, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		failed = append(failed, fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+
+	if p.MinClasses > 0 && characterClasses(pw) < p.MinClasses {
+		failed = append(failed, fmt.Sprintf("must contain characters from at least %d of the classes "+
+			"lower case letters, upper case letters, digits and others", p.MinClasses))
+	}
+
+	if !p.AllowAddress {
+		for _, part := range addressParts(user, domain) {
+			if strings.Contains(strings.ToLower(pw), part) {
+				failed = append(failed, fmt.Sprintf("must not contain %q from the mailbox address", part))
+			}
+		}
+	}
+
+	if p.BreachedList != "" {
+		breached, err := breachedPassword(p.BreachedList, pw)
+		if err != nil {
+			return fmt.Errorf("checking the list of breached passwords failed: %v", err)
+		}
+
+		if breached {
+			failed = append(failed, "must not be in the list of breached passwords")
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// characterClasses returns the number of different character classes in pw.
+func characterClasses(pw string) int {
+	var lower, upper, digit, other int
+	for _, r := range pw {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+// addressParts returns the lower case local part, the domain and the labels
+// of the domain (without the top level domain) for the address user@domain,
+// short parts are ignored.
+func addressParts(user, domain string) []string {
+	const minLength = 3
+
+	var parts []string
+	add := func(s string) {
+		s = strings.ToLower(s)
+		if utf8.RuneCountInString(s) < minLength {
+			return
+		}
+
+		for _, p := range parts {
+			if p == s {
+				return
+			}
+		}
+		parts = append(parts, s)
+	}
+
+	add(user)
+	add(domain)
+
+	labels := strings.Split(domain, ".")
+	for _, label := range labels[:len(labels)-1] {
+		add(label)
+	}
+
+	return parts
+}
+
+// breachedPassword returns true if the SHA1 hash of pw is contained in the
+// list of breached passwords. When list is a directory, it must contain one
+// file for each prefix (the first five hex characters of the hash, as served
+// by the k-anonymity API of Have I Been Pwned) named after the prefix, with
+// lines in the format "SUFFIX:COUNT". Otherwise list is a file with lines in
+// the format "HASH:COUNT", sorted by the hash. The count is optional.
+func breachedPassword(list, pw string) (bool, error) {
+	sum := sha1.Sum([]byte(pw))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	fi, err := os.Stat(list)
+	if err != nil {
+		return false, err
+	}
+
+	if !fi.IsDir() {
+		f, err := os.Open(list)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+
+		return searchSortedHashes(f, fi.Size(), hash)
+	}
+
+	prefix, suffix := hash[:5], hash[5:]
+
+	var f *os.File
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err = os.Open(filepath.Join(list, name))
+		if err == nil {
+			break
+		}
+
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+
+	if f == nil {
+		// no file for the prefix, so no password with the prefix is known
+		return false, nil
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if hashKey(sc.Text()) == suffix {
+			return true, nil
+		}
+	}
+
+	return false, sc.Err()
+}
+
+// hashKey returns the upper case hash from a line "HASH:COUNT".
+func hashKey(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(line))
+}
+
+// searchSortedHashes runs a binary search for hash in the sorted file rd of
+// the given size.
+func searchSortedHashes(rd io.ReaderAt, size int64, hash string) (bool, error) {
+	// lineAt returns the first line starting at or after offset
+	lineAt := func(offset int64) (string, error) {
+		start := offset
+		if start > 0 {
+			// also read the preceding byte to find out if a line starts at offset
+			start--
+		}
+
+		buf := make([]byte, 256)
+		n, err := rd.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		buf = buf[:n]
+
+		if offset > 0 {
+			i := bytes.IndexByte(buf, '\n')
+			if i < 0 {
+				return "", nil
+			}
+			buf = buf[i+1:]
+		}
+
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			buf = buf[:i]
+		}
+
+		return string(buf), nil
+	}
+
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		line, err := lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+
+		if line == "" || hashKey(line) >= hash {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	line, err := lineAt(lo)
+	if err != nil {
+		return false, err
+	}
+
+	return hashKey(line) == hash, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	p := passwordPolicy{
+		MinLength:  10,
+		MaxLength:  20,
+		MinClasses: 3,
+	}
+
+	var tests = []struct {
+		pw     string
+		failed int
+	}{
+		{"Correct-Horse1", 0},
+		{"short", 2},
+		{"alllowercaseletters", 1},
+		{"Correct-Horse1-Battery-Staple", 1},
+		{"Alice-Secret-1", 1},
+		{"example-1234-A", 1},
+		{"Alice@example.com1", 3},
+	}
+
+	for _, test := range tests {
+		err := p.Check(test.pw, "alice", "example.com")
+		if test.failed == 0 {
+			if err != nil {
+				t.Errorf("password %q: unexpected error %v", test.pw, err)
+			}
+			continue
+		}
+
+		failed, ok := err.(policyError)
+		if !ok {
+			t.Errorf("password %q: want policy error, got %v", test.pw, err)
+			continue
+		}
+
+		if len(failed) != test.failed {
+			t.Errorf("password %q: want %d failed rules, got %d: %v", test.pw, test.failed, len(failed), failed)
+		}
+	}
+
+	p.AllowAddress = true
+	err := p.Check("Alice-Secret-1", "alice", "example.com")
+	if err != nil {
+		t.Errorf("unexpected error with AllowAddress: %v", err)
+	}
+}
+
+var testBreachedPasswords = []string{"password", "123456", "letmein", "qwerty", "correct horse battery staple"}
+
+func TestBreachedPasswordFile(t *testing.T) {
+	var lines []string
+	for i, pw := range testBreachedPasswords {
+		lines = append(lines, sha1Hex(pw)+":"+strings.Repeat("1", i+1))
+	}
+	// add some more hashes so that the binary search has work to do
+	for i := 1; i < 500; i++ {
+		lines = append(lines, sha1Hex(strings.Repeat("x", i))+":1")
+	}
+	sort.Strings(lines)
+
+	filename := filepath.Join(t.TempDir(), "pwned.txt")
+	err := ioutil.WriteFile(filename, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pw := range append(testBreachedPasswords, strings.Repeat("x", 250)) {
+		breached, err := breachedPassword(filename, pw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !breached {
+			t.Errorf("password %q not found in list", pw)
+		}
+	}
+
+	for _, pw := range []string{"Correct-Horse1", "", "0"} {
+		breached, err := breachedPassword(filename, pw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if breached {
+			t.Errorf("password %q found in list", pw)
+		}
+	}
+}
+
+func TestBreachedPasswordDir(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, pw := range testBreachedPasswords {
+		hash := sha1Hex(pw)
+		err := ioutil.WriteFile(filepath.Join(dir, hash[:5]), []byte("0000000000000000000000000000000000A:3\n"+hash[5:]+":10\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := passwordPolicy{BreachedList: dir}
+	for _, pw := range testBreachedPasswords {
+		err := p.Check(pw, "user", "example.com")
+		if _, ok := err.(policyError); !ok {
+			t.Errorf("password %q: want policy error, got %v", pw, err)
+		}
+	}
+
+	err := p.Check("Correct-Horse1", "user", "example.com")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
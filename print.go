@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"strings"
@@ -19,3 +20,22 @@ func warn(s string, args ...interface{}) {
 	}
 	fmt.Fprintf(os.Stderr, s, args...)
 }
+
+// confirm asks the user a yes/no question on stdout and returns true if the
+// answer read from stdin is "y" or "yes".
+func confirm(question string, args ...interface{}) (bool, error) {
+	fmt.Printf(question+" [y/N] ", args...)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Printf("\n")
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migration changes the database schema from version-1 to version (Up) and
+// back (Down).
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations returns the migrations for the dialect, sorted by version.
+// The files are named like NNNN_name.up.sql and NNNN_name.down.sql.
+func loadMigrations(d dialect) ([]migration, error) {
+	dir := path.Join("migrations", d.Name)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(filename, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("invalid migration file name %v", filename)
+		}
+
+		base := strings.TrimSuffix(filename, "."+direction+".sql")
+		data := strings.SplitN(base, "_", 2)
+		if len(data) != 2 {
+			return nil, fmt.Errorf("invalid migration file name %v", filename)
+		}
+
+		version, err := strconv.Atoi(data[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid version in migration file name %v", filename)
+		}
+
+		buf, err := fs.ReadFile(migrationFiles, path.Join(dir, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: data[1]}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(buf)
+		} else {
+			m.Down = string(buf)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%v is incomplete", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// splitStatements returns the individual SQL statements in script, which
+// are terminated by a semicolon at the end of a line.
+func splitStatements(script string) []string {
+	var (
+		stmts []string
+		cur   []string
+	)
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		cur = append(cur, line)
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.Join(cur, "\n"), ";"))
+			cur = nil
+		}
+	}
+
+	if len(cur) > 0 {
+		stmts = append(stmts, strings.Join(cur, "\n"))
+	}
+
+	return stmts
+}
+
+// errSchemaNotVersioned is returned when the schema_version table does not
+// exist, e.g. for databases created with the howto or an earlier version of
+// vmail.
+var errSchemaNotVersioned = errors.New("database schema is not versioned, run 'vmail schema init' once (and then 'vmail schema migrate') to upgrade an existing database")
+
+// tableExists returns true if the table is present in the database.
+func (db *DB) tableExists(name string) (bool, error) {
+	var n int
+	err := db.get(&n, db.dialect.TableExists, name)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// SchemaVersion returns the version of the database schema.
+func (db *DB) SchemaVersion() (int, error) {
+	ok, err := db.tableExists("schema_version")
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, errSchemaNotVersioned
+	}
+
+	var version int
+	err = db.get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// LatestSchemaVersion returns the version of the schema this program needs.
+func (db *DB) LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(migrations), nil
+}
+
+// CheckSchema returns an error unless the database schema is up to date.
+func (db *DB) CheckSchema() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest, err := db.LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version < latest:
+		return fmt.Errorf("database schema version %d is outdated (need %d), run 'vmail schema migrate'", version, latest)
+	case version > latest:
+		return fmt.Errorf("database schema version %d is newer than supported by this program (%d)", version, latest)
+	}
+
+	return nil
+}
+
+// initialColumns are the columns of the tables in the initial schema (version
+// 1), as created by the howto.
+var initialColumns = map[string]string{
+	"domains":     "id, domain",
+	"accounts":    "id, username, domain, password, quota, enabled, sendonly",
+	"aliases":     "id, source_username, source_domain, destination_username, destination_domain, blacklisted, enabled",
+	"tlspolicies": "id, domain, policy, params",
+}
+
+// checkColumns verifies that the tables from the initial schema have all the
+// columns vmail uses.
+func (db *DB) checkColumns() error {
+	for table, columns := range initialColumns {
+		rows, err := db.q.Query("SELECT " + columns + " FROM " + table + " WHERE 1 = 0")
+		if err != nil {
+			return fmt.Errorf("table %v does not match the schema: %v", table, err)
+		}
+		_ = rows.Close()
+	}
+
+	return nil
+}
+
+// InitSchema creates the schema_version table and all tables in an empty
+// database. When the tables from the howto already exist, the columns are
+// checked and the database is marked as being at version 1 instead.
+func (db *DB) InitSchema() (baseline bool, err error) {
+	versioned, err := db.tableExists("schema_version")
+	if err != nil {
+		return false, err
+	}
+
+	if versioned {
+		return false, errors.New("database schema is already versioned")
+	}
+
+	existing, err := db.tableExists("domains")
+	if err != nil {
+		return false, err
+	}
+
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return false, err
+	}
+
+	if existing {
+		err = db.checkColumns()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = db.exec(`CREATE TABLE schema_version (
+		version integer NOT NULL PRIMARY KEY,
+		name varchar(255) NOT NULL,
+		applied_at varchar(64) NOT NULL
+	)`)
+	if err != nil {
+		return false, err
+	}
+
+	if existing {
+		_, err = db.exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+			migrations[0].Version, migrations[0].Name, time.Now().Format(time.RFC3339))
+		return true, err
+	}
+
+	return false, db.Migrate(len(migrations), nil)
+}
+
+// Migrate applies the migrations needed to move the schema to the target
+// version, which may be lower than the current version. Before each migration
+// is run, report is called (if set).
+func (db *DB) Migrate(target int, report func(m migration, up bool)) error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported by this program (%d)", version, len(migrations))
+	}
+
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("invalid target version %d (latest is %d)", target, len(migrations))
+	}
+
+	for version < target {
+		m := migrations[version]
+		if report != nil {
+			report(m, true)
+		}
+
+		err = db.runMigration(m.Up, func(tx sqlx.Execer) error {
+			_, err := tx.Exec(db.dialect.Rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+				m.Version, m.Name, time.Now().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%v failed: %v", m.Version, m.Name, err)
+		}
+		version++
+	}
+
+	for version > target {
+		m := migrations[version-1]
+		if report != nil {
+			report(m, false)
+		}
+
+		err = db.runMigration(m.Down, func(tx sqlx.Execer) error {
+			_, err := tx.Exec(db.dialect.Rebind("DELETE FROM schema_version WHERE version = ?"), m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %04d_%v failed: %v", m.Version, m.Name, err)
+		}
+		version--
+	}
+
+	return nil
+}
+
+// runMigration executes all statements in script and then calls record in
+// a transaction. MySQL commits schema changes implicitly, so a failed
+// migration may need to be cleaned up manually there.
+func (db *DB) runMigration(script string, record func(sqlx.Execer) error) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range splitStatements(script) {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	err = record(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
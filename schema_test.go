@@ -0,0 +1,198 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB returns a new SQLite database with the latest schema.
+func newTestDB(t testing.TB) *DB {
+	db, err := ConnectDB("sqlite://" + filepath.Join(t.TempDir(), "vmail.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	_, err = db.InitSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// useTestDB sets opts.db to a new test database for running commands.
+func useTestDB(t testing.TB) *DB {
+	db := newTestDB(t)
+
+	prev := opts.db
+	opts.db = db
+	t.Cleanup(func() {
+		opts.db = prev
+	})
+
+	return db
+}
+
+func TestMigrationsMatch(t *testing.T) {
+	base, err := loadMigrations(dialectMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []dialect{dialectSQLite, dialectPostgres} {
+		migrations, err := loadMigrations(d)
+		if err != nil {
+			t.Fatalf("%v: %v", d.Name, err)
+		}
+
+		if len(migrations) != len(base) {
+			t.Fatalf("%v: want %d migrations, got %d", d.Name, len(base), len(migrations))
+		}
+
+		for i, m := range migrations {
+			if m.Name != base[i].Name {
+				t.Errorf("%v: migration %d has name %q, want %q", d.Name, m.Version, m.Name, base[i].Name)
+			}
+		}
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.CheckSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := db.LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target := latest - 1; target >= 0; target-- {
+		err = db.Migrate(target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version, err := db.SchemaVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version != target {
+			t.Fatalf("wrong version after migrating down, want %d, got %d", target, version)
+		}
+	}
+
+	ok, err := db.tableExists("domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("table domains still exists at version 0")
+	}
+
+	err = db.Migrate(latest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CheckSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitSchemaBaseline(t *testing.T) {
+	db, err := ConnectDB("sqlite://" + filepath.Join(t.TempDir(), "vmail.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stmt := range splitStatements(migrations[0].Up) {
+		_, err = db.exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	baseline, err := db.InitSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !baseline {
+		t.Fatal("existing tables were not detected")
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 1 {
+		t.Fatalf("wrong version after baseline, want 1, got %d", version)
+	}
+}
+
+func TestInitSchemaBaselineMissingTable(t *testing.T) {
+	db, err := ConnectDB("sqlite://" + filepath.Join(t.TempDir(), "vmail.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stmt := range splitStatements(migrations[0].Up) {
+		_, err = db.exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = db.exec("DROP TABLE tlspolicies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.InitSchema()
+	if err == nil {
+		t.Fatal("missing table tlspolicies was not detected")
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	db := newTestDB(t)
+
+	latest, err := db.LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		latest+1, "future", "2038-01-19T03:14:07Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Migrate(latest, nil)
+	if err == nil {
+		t.Fatal("migrating a newer schema did not return an error")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tlsPolicyAttributes lists the attributes Postfix accepts for each policy in
+// smtp_tls_policy_maps.
+var tlsPolicyAttributes = map[string][]string{
+	"none":        nil,
+	"may":         {"ciphers", "protocols", "exclude", "servername", "connection_reuse"},
+	"encrypt":     {"ciphers", "protocols", "exclude", "servername", "connection_reuse"},
+	"dane":        {"ciphers", "protocols", "exclude", "servername", "connection_reuse"},
+	"dane-only":   {"ciphers", "protocols", "exclude", "servername", "connection_reuse"},
+	"fingerprint": {"ciphers", "protocols", "exclude", "servername", "connection_reuse", "match"},
+	"verify":      {"ciphers", "protocols", "exclude", "servername", "connection_reuse", "match", "tafile"},
+	"secure":      {"ciphers", "protocols", "exclude", "servername", "connection_reuse", "match", "tafile"},
+}
+
+// tlsPolicies returns the names of all policies, sorted.
+func tlsPolicies() []string {
+	var names []string
+	for name := range tlsPolicyAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// tlsProtocols are the protocol names accepted in the protocols attribute.
+var tlsProtocols = []string{"SSLv2", "SSLv3", "TLSv1", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+
+// checkTLSPolicy returns an error if Postfix does not accept the policy with
+// the params, which are attributes like "match=.example.com" separated by
+// whitespace.
+func checkTLSPolicy(policy, params string) error {
+	attrs, ok := tlsPolicyAttributes[policy]
+	if !ok {
+		return fmt.Errorf("unknown TLS policy %q, supported are: %v", policy, strings.Join(tlsPolicies(), ", "))
+	}
+
+	allowed := make(map[string]bool)
+	for _, attr := range attrs {
+		allowed[attr] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, param := range strings.Fields(params) {
+		data := strings.SplitN(param, "=", 2)
+		if len(data) != 2 || data[1] == "" {
+			return fmt.Errorf("invalid parameter %q, use name=value", param)
+		}
+
+		name, value := data[0], data[1]
+		if !allowed[name] {
+			if len(attrs) == 0 {
+				return fmt.Errorf("TLS policy %v does not accept parameters", policy)
+			}
+			return fmt.Errorf("parameter %v is not valid for TLS policy %v (valid are: %v)", name, policy, strings.Join(attrs, ", "))
+		}
+
+		// Postfix combines several match attributes, e.g. one for each
+		// certificate fingerprint
+		if seen[name] && name != "match" {
+			return fmt.Errorf("parameter %v passed more than once", name)
+		}
+		seen[name] = true
+
+		err := checkTLSPolicyParam(policy, name, value)
+		if err != nil {
+			return fmt.Errorf("parameter %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkTLSPolicyParam checks the value of the attribute name.
+func checkTLSPolicyParam(policy, name, value string) error {
+	switch name {
+	case "ciphers":
+		switch value {
+		case "export", "low", "medium", "high", "null":
+			return nil
+		}
+		return fmt.Errorf("invalid cipher grade %q (export, low, medium, high or null)", value)
+
+	case "connection_reuse":
+		if value != "yes" && value != "no" {
+			return fmt.Errorf("invalid value %q (yes or no)", value)
+		}
+
+	case "protocols":
+		for _, p := range strings.FieldsFunc(value, func(r rune) bool { return r == ':' || r == ',' }) {
+			p = strings.TrimPrefix(p, "!")
+			p = strings.TrimPrefix(strings.TrimPrefix(p, ">="), "<=")
+			if !contains(tlsProtocols, p) {
+				return fmt.Errorf("unknown protocol %q", p)
+			}
+		}
+
+	case "match":
+		if policy == "fingerprint" {
+			// fingerprints are hex digests, the bytes may be separated by colons
+			for _, fp := range strings.Split(value, "|") {
+				buf, err := hex.DecodeString(strings.Replace(fp, ":", "", -1))
+				if err != nil || len(buf) == 0 {
+					return fmt.Errorf("invalid fingerprint %q", fp)
+				}
+			}
+			return nil
+		}
+
+		// names are host names, or the strategies "hostname", "nexthop"
+		// and "dot-nexthop"
+		for _, pattern := range strings.Split(value, ":") {
+			if !validHostPattern(pattern) {
+				return fmt.Errorf("invalid name %q", pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validHostPattern returns true if s is a host name, optionally starting with
+// a dot to match all subdomains.
+func validHostPattern(s string) bool {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return false
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if label == "" {
+			return false
+		}
+
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// contains returns true if list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestCheckTLSPolicy(t *testing.T) {
+	var tests = []struct {
+		policy string
+		params string
+		valid  bool
+	}{
+		{"none", "", true},
+		{"may", "", true},
+		{"encrypt", "protocols=!SSLv2:!SSLv3 ciphers=high", true},
+		{"dane-only", "protocols=>=TLSv1.2", true},
+		{"secure", "match=.example.com:nexthop", true},
+		{"verify", "match=hostname tafile=/etc/postfix/ta.pem", true},
+		{"fingerprint", "match=3D:95:34:51:24:66:33:B9:D2:40:99:C0:C1:17:0B:D1|EC:3B:2D:C2:1B:B9:6E:DE", true},
+		{"secure", "connection_reuse=yes servername=mx.example.com", true},
+		{"secure", "match=.example.com match=.example.org", true},
+		{"fingerprint", "match=3D:95:34:51:24:66:33:B9:D2:40:99:C0:C1:17:0B:D1 match=EC:3B:2D:C2:1B:B9:6E:DE", true},
+
+		{"strict", "", false},
+		{"Secure", "", false},
+		{"none", "ciphers=high", false},
+		{"may", "match=.example.com", false},
+		{"encrypt", "ciphers=strong", false},
+		{"encrypt", "protocols=TLSv2", false},
+		{"secure", "match=", false},
+		{"secure", "match", false},
+		{"secure", "match=foo..example.com", false},
+		{"encrypt", "ciphers=high ciphers=medium", false},
+		{"fingerprint", "match=zz:yy", false},
+		{"verify", "connection_reuse=maybe", false},
+	}
+
+	for _, test := range tests {
+		err := checkTLSPolicy(test.policy, test.params)
+		if test.valid && err != nil {
+			t.Errorf("%v %q: unexpected error %v", test.policy, test.params, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%v %q: no error", test.policy, test.params)
+		}
+	}
+}